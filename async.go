@@ -0,0 +1,22 @@
+package main
+
+// CallResult bundles whatever a call gives back
+type CallResult struct {
+	Content    interface{}
+	Fallbacked bool
+	Err        error
+}
+
+// CallAsync is the same as Call but it doesn't block, the result comes
+// through the channel once it is ready
+func (cb *CircuitBreaker) CallAsync() <-chan CallResult {
+	// Buffered, so it doesn't leak if nobody is waiting for the result anymore
+	resultChannel := make(chan CallResult, 1)
+
+	go func() {
+		res, fallbacked, err := cb.Call()
+		resultChannel <- CallResult{res, fallbacked, err}
+	}()
+
+	return resultChannel
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCallAsyncGivesSameAsCall(t *testing.T) {
+	cb, _ := createCircuitBreaker(healthService, fallback)
+	res, fallbacked, err := cb.Call()
+
+	var results []<-chan CallResult
+	for i := 0; i < 5; i++ {
+		results = append(results, cb.CallAsync())
+	}
+	for _, result := range results {
+		assert.Equal(t, CallResult{res, fallbacked, err}, <-result)
+	}
+}
+
+func TestCallAsyncWithFallback(t *testing.T) {
+	cb, _ := createCircuitBreaker(failingService, fallback)
+
+	result := <-cb.CallAsync()
+	assert.True(t, result.Fallbacked)
+	assert.Equal(t, fallbackContent, result.Content)
+	assert.Contains(t, result.Err.Error(), fallbackDueToErrorMessage)
+	assert.Equal(t, 1, cb.CurrentFailureCount())
+}
+
+func TestCallAsyncDoesNotBlock(t *testing.T) {
+	cb, _ := createCircuitBreaker(createSleepyService(100*time.Millisecond), fallback)
+
+	start := time.Now()
+	var results []<-chan CallResult
+	for i := 0; i < 5; i++ {
+		results = append(results, cb.CallAsync())
+	}
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+
+	for _, result := range results {
+		select {
+		case r := <-result:
+			assert.Nil(t, r.Err)
+			assert.Equal(t, healthServiceContent, r.Content)
+		case <-time.After(cb.Settings.Timeout):
+			t.Fatal("async call never gave a result")
+		}
+	}
+}
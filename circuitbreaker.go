@@ -1,15 +1,26 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"math/rand"
+	"strings"
+	"sync"
 	"time"
 )
 
 // Default value for missing settings on CircuitBreak creation
 const (
-	DefautTimeout           time.Duration = 2000
-	DefaultRetryTimePeriod  time.Duration = 3000
-	DefautlFailureThreshold int           = 2
+	DefautTimeout            time.Duration = 2000 * time.Millisecond
+	DefaultRetryTimePeriod   time.Duration = 3000 * time.Millisecond
+	DefautlFailureThreshold  int           = 2
+	DefaultSuccessThreshold  int           = 1
+	DefaultMaxFailureRecords int           = 100
+	DefaultMaxHistory        int           = 100
+	DefaultClockResolution   time.Duration = time.Millisecond
 )
 
 // CircuitState flags the state of the circuit
@@ -42,37 +53,238 @@ func (s CircuitState) ToString() string {
 	}
 }
 
-// CircuitEvent is used for callback purposes
+// MarshalJSON gives the state as a string, e.g. "half-open"
+func (s CircuitState) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.ToString())
+}
+
+// UnmarshalJSON takes the state back from a string given by MarshalJSON
+func (s *CircuitState) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return err
+	}
+	state, err := ParseCircuitState(str)
+	if err != nil {
+		return err
+	}
+	*s = state
+	return nil
+}
+
+// ParseCircuitState is the other way around of ToString, no matter the case,
+// e.g. "Half-Open" gives IsHalfOpen
+func ParseCircuitState(str string) (CircuitState, error) {
+	for _, state := range []CircuitState{IsClosed, IsHalfOpen, IsOpen} {
+		if strings.EqualFold(state.ToString(), str) {
+			return state, nil
+		}
+	}
+	return 0, fmt.Errorf("Circuit state %q is unknown", str)
+}
+
+// Clock tells what time it is, so that tests don't need to wait for real
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// CircuitEvent is used for callback purposes. Callbacks run outside of the
+// circuit lock, so they may look into it, e.g. State or Metrics, but they
+// should not call it, e.g. Call or Trip, as that waits for them to be done.
 type CircuitEvent func()
 
 // CircuitSettings is the spec to build a CircuitBreaker instance
 type CircuitSettings struct {
+	// Tells this circuit apart from others in errors and logs, e.g. "payments"
+	Name string
 	// Target service
 	Service Callable
 	// Fallback when service is unhealth
 	Fallback Callable
-	// Request timeout in milliseconds
+	// Fallback that is told why it was called, preferred over Fallback when set
+	FallbackWithCause FallbackFunc
+	// Fallback that also tells about its content, e.g. how stale a cached one
+	// is, preferred over Fallback when set, see CallWithMeta
+	FallbackWithMeta MetaFallbackFunc
+	// Fallbacks to try in order, after the ones above, until one makes it,
+	// e.g. a cache and then a static default
+	Fallbacks []Callable
+	// How much time should be left on the caller's deadline for the fallback
+	// to be worth calling, zero means it is always called
+	FallbackMinTime time.Duration
+	// Request timeout
 	Timeout time.Duration
-	// Grace time in milliseconds to wait before a new call to the service
+	// Request timeout while half-open, zero means the same as Timeout
+	HalfOpenTimeout time.Duration
+	// Grace time to wait before a new call to the service
 	RetryTimePeriod time.Duration
-	// How many fails should we tolerate
+	// Grace time to wait the first time it opens, which is where backoff
+	// starts from, though never longer than MaxRetryTimePeriod, zero means
+	// the same as RetryTimePeriod
+	InitialRetryPeriod time.Duration
+	// How much longer should we wait after each failed chance, zero means no backoff
+	BackoffMultiplier float64
+	// How long at most should we wait when backing off, zero means no limit
+	MaxRetryTimePeriod time.Duration
+	// How much randomly longer or shorter should we wait, so that many circuits
+	// tripped at once don't call the service back all at the same time
+	RetryJitter time.Duration
+	// Source of randomness for the jitter, nil means one seeded by the clock
+	RandSource rand.Source
+	// Tells what time it is, nil means the real clock
+	Clock Clock
+	// How fine the clock tells time apart, retry periods shorter than that,
+	// jitter included, are taken as that long so that it neither gets stuck
+	// open nor goes half-open right away
+	ClockResolution time.Duration
+	// Which state it starts at, either IsClosed, the default, or IsOpen for a
+	// downstream that is known to be down already
+	InitialState CircuitState
+	// Where to load the state from at creation and save it to on changes,
+	// nil means it is kept in memory only
+	StateStore StateStore
+	// How many fails it takes to trip, i.e. with N it opens right on the Nth
+	// fail and stays closed up to the one before
 	FailureThreshold int
+	// How many fails it takes to trip given how many calls there were lately,
+	// i.e. the ones within RollingWindowSize, e.g. so that it is not as
+	// trigger-happy while there is little traffic. When set it takes the
+	// place of FailureThreshold, and it needs RollingWindowSize.
+	ThresholdFunc func(recentRequests int) int
+	// How long may the service keep failing, with no success in between,
+	// before it trips no matter how many fails, e.g. a slow but steady
+	// degradation, whether it goes by FailureThreshold or ErrorPercentThreshold
+	// otherwise. Zero means it goes by the fails only.
+	TripAfter time.Duration
+	// How far back should we look for fails, zero means since ever
+	WindowDuration time.Duration
+	// How long with no calls at all until fails short of tripping are
+	// forgotten, zero means they are kept for good
+	IdleResetTimeout time.Duration
+	// How long after a fail should further fails count as the same one, e.g.
+	// a retry loop going off, zero means every fail counts
+	FailureDebounce time.Duration
+	// How many of the most recent fails should we keep record of
+	MaxFailureRecords int
+	// How many of the most recent state transitions should we keep record of
+	MaxHistory int
+	// Which percentage of fails among the latest calls should we tolerate, so
+	// it trips once they are over it, when set it takes the place of
+	// FailureThreshold
+	ErrorPercentThreshold int
+	// How many of the latest calls should we look at for the percentage
+	RollingWindowSize int
+	// How many calls should we see before the percentage matters, zero means
+	// as many as RollingWindowSize
+	MinRequestVolume int
+	// How long may a successful call take before it is deemed slow, zero means no limit
+	SlowCallThreshold time.Duration
+	// Which percentage of slow calls among the latest RollingWindowSize calls
+	// should we tolerate, so it trips once they are over it, zero means slow
+	// calls never trip the circuit
+	SlowCallRateThreshold int
+	// How many successes in a row should we see before closing a half-open circuit
+	SuccessThreshold int
+	// How many calls at once may go to the service while half-open, zero means no limit
+	HalfOpenMaxCalls int
+	// Whether calls while half-open should get the actual service error instead of a fallback
+	ProbeWithoutFallback bool
+	// Whether a fallback that made it should come with no error at all, the
+	// fallbacked flag still tells it apart from the service response
+	TreatFallbackAsSuccess bool
+	// Whether calls while open should get ErrCircuitOpen right away, with no
+	// fallback whatsoever
+	FailFast bool
+	// Whether it should only pretend, e.g. before going live, so every call
+	// goes to the service and gets its response as it is, with no fallback,
+	// while state and metrics tell what would have happened
+	ShadowMode bool
+	// How many calls at once may go to the service at all, zero means no limit
+	MaxConcurrentCalls int
+	// How long at least between calls that go to the service, the ones in
+	// between get the fallback, zero means no limit
+	MinCallInterval time.Duration
+	// Tells which errors returned by the service are worth a fail, nil means all of them
+	IsFailure func(error) bool
+	// How many fails a single one is worth, e.g. a timeout might be worse
+	// than a bad response, nil means they are all worth one
+	FailureWeight func(error) int
+	// Whether a service that responds nil with no error is fine, e.g. a cache miss
+	AllowNilResponse bool
+	// Tells whether the service response is a success, content included, e.g.
+	// a body with an error status in it. When set it takes the place of the
+	// checks above.
+	IsSuccess func(content interface{}, err error) bool
 	// It happens when the circuit trips
 	OnTrip CircuitEvent
 	// It happens when the circuit get closed again
 	OnReset CircuitEvent
+	// It happens when the circuit gives the service a chance to recover
+	OnHalfOpen CircuitEvent
 	// It happens whenever state changes
 	OnStateChange CircuitEvent
+	// It happens whenever state changes too, along with which states
+	OnTransition func(from, to CircuitState)
+	// It happens on every call that doesn't get to the service because it is open
+	OnReject CircuitEvent
+	// It happens on every fail, along with what went wrong
+	OnFailure func(err error)
+	// It happens on every healthy call, along with how long it took
+	OnSuccess func(latency time.Duration)
+	// How long at least between state change callbacks, so a flapping circuit
+	// doesn't flood whoever listens. Changes in between are coalesced into
+	// one from the last state told about to the latest one. Zero means every
+	// change is told right away.
+	CallbackMinInterval time.Duration
+	// Where to tell about state changes and fails, nil means nowhere
+	Logger *slog.Logger
+	// Where to push metrics to as calls go, nil means nowhere
+	MetricsHook MetricsHook
 }
 
 // Callable is the actual call to a service or it might as well be a fallback
 type Callable func() (interface{}, error)
 
+// StaticFallback is a fallback that always responds the given content, e.g.
+// a default value
+func StaticFallback(content interface{}) Callable {
+	return func() (interface{}, error) {
+		return content, nil
+	}
+}
+
+// FallbackFunc is a fallback that gets the error which made it necessary
+type FallbackFunc func(cause error) (interface{}, error)
+
 type callableResponse struct {
 	Content interface{}
 	Error   error
 }
 
+// Reasons behind errors, so callers can tell them apart with errors.Is
+var (
+	// The circuit is open, so the service was not called
+	ErrCircuitOpen = fmt.Errorf("Circuit is open")
+	// There were too many calls at once, so the service was not called
+	ErrBulkheadFull = fmt.Errorf("Bulkhead is full")
+	// The service took too long to respond
+	ErrServiceTimeout = fmt.Errorf("Service timed out")
+	// There was no fallback to rely on
+	ErrNoFallback = fmt.Errorf("Service has no fallback")
+	// The service was called too recently, so it was not called again
+	ErrCallTooSoon = fmt.Errorf("Service was called too soon")
+	// The caller gave up on the call before the service responded
+	ErrCallCancelled = fmt.Errorf("Call was cancelled")
+	// The circuit breaker was closed for good, so it takes no more calls
+	ErrClosed = fmt.Errorf("Circuit breaker is closed")
+)
+
 // CallingError is an error that occurs on a callable action
 type CallingError struct {
 	Cause error
@@ -82,16 +294,132 @@ func (e *CallingError) Error() string {
 	return fmt.Sprintf("Error when calling service: %s", e.Cause.Error())
 }
 
+// Unwrap gives the cause, so errors.Is and errors.As can look into it
+func (e *CallingError) Unwrap() error {
+	return e.Cause
+}
+
+// fallbackedError tells that the fallback made it, so that it can be let go
+// when TreatFallbackAsSuccess says so
+type fallbackedError struct {
+	error
+}
+
+func (e *fallbackedError) Unwrap() error {
+	return e.error
+}
+
+// CircuitError is what the circuit itself has to say, e.g. it is open or it
+// relied on the fallback, along with how the circuit was right after the call
+type CircuitError struct {
+	Name         string
+	State        CircuitState
+	FailureCount int
+	Cause        error
+}
+
+func (e *CircuitError) Error() string {
+	if e.Name == "" {
+		return e.Cause.Error()
+	}
+	return fmt.Sprintf("[%s] %s", e.Name, e.Cause.Error())
+}
+
+// Unwrap gives the cause, so errors.Is and errors.As can look into it
+func (e *CircuitError) Unwrap() error {
+	return e.Cause
+}
+
 // CircuitBreaker object itself
 type CircuitBreaker struct {
-	// Spec to follow
+	// Spec to follow, though changing it while there are calls on their way is
+	// a race, so better use Configure
 	Settings CircuitSettings
 	// It is the last time the service failed
 	LastFailureTime time.Time
-	// How many time the service failed
+	// How many time the service failed, though reading it while there are
+	// calls on their way is a race, so better use CurrentFailureCount
 	FailureCount int
 	// A record of all errors that happenend since last time it was cool
 	FailureRecord []string
+	// When each one of the errors in the record happened
+	FailureTimes []time.Time
+	// How many times in a row the service succeeded while half-open
+	SuccessCount int
+	// When the service started failing with no success since then
+	streakStart time.Time
+	// Guards the fields above against concurrent calls
+	mutex sync.RWMutex
+	// Serializes state updates along with their events, so callbacks
+	// see a consistent circuit while they run
+	eventMutex sync.Mutex
+	// The state we have last notified about
+	lastState CircuitState
+	// When state change callbacks last fired, as far as CallbackMinInterval
+	// is concerned
+	lastCallbackTime time.Time
+	// Fires the state change callbacks held back by CallbackMinInterval
+	callbackTimer *time.Timer
+	// The state callbacks were last told about while they are held back
+	pendingFrom CircuitState
+	// Whether Close was called, so it is done for good
+	shutdown bool
+	// Keeps the circuit open until it is explicitly cleared
+	forcedOpen bool
+	// Lets a zero Timeout mean no timeout at all, so when no other timeout
+	// nor context applies either, the service is called right on the caller's
+	// goroutine and tests don't have to deal with goroutines of ours
+	synchronous bool
+	// Outcome of the latest calls, true meaning success, as a ring buffer
+	outcomes []bool
+	// Whether each of the latest calls was slow, alongside outcomes
+	slowOutcomes []bool
+	// Where the next outcome goes in the ring once it is full
+	outcomeIndex int
+	// How many fails are counted but were trimmed off the record
+	trimmedFailures int
+	// How many chances in a row the service missed while half-open
+	failedProbes int
+	// How many of the fails were timeouts rather than errors
+	timeoutCount int
+	// When was the latest call, as far as IdleResetTimeout is concerned
+	lastCallTime time.Time
+	// When was the latest call that went to the service, as far as
+	// MinCallInterval is concerned
+	lastServiceCall time.Time
+	// How many calls are going to the service right now while half-open
+	halfOpenCalls int
+	// Semaphore for calls going to the service right now
+	bulkhead chan struct{}
+	// How much longer or shorter we wait this time
+	retryJitter time.Duration
+	// Randomness for the jitter
+	random *rand.Rand
+	// What time is it
+	clock Clock
+	// Lifetime counters
+	metrics Metrics
+	// How long the latest service call took
+	lastLatency time.Duration
+	// How many service calls the average latency is made of
+	latencySamples int64
+	// The most recent state transitions
+	history []StateTransition
+	// Whoever wants to hear about state transitions
+	subscribers []chan StateTransition
+}
+
+// FailureEntry is a failure along with when it happened
+type FailureEntry struct {
+	Time time.Time
+	Err  string
+}
+
+// FailureGroup is a run of failures in a row that went the same way
+type FailureGroup struct {
+	Err      string
+	Count    int
+	LastSeen time.Time
 }
 
 // NewCircuitBreaker builds a circuit breaker from a settings spec
@@ -99,7 +427,66 @@ func NewCircuitBreaker(settings CircuitSettings) (*CircuitBreaker, error) {
 	if settings.Service == nil {
 		return nil, fmt.Errorf("You must provide a service to be called")
 	}
+	return NewManualCircuitBreaker(settings)
+}
+
+// NewManualCircuitBreaker builds a circuit breaker for callers who call the
+// service on their own, e.g. over a long-lived connection, and then tell how
+// it went through Allow, ReportSuccess and ReportFailure. Service and Fallback
+// are optional here, so Call is of no use without them.
+func NewManualCircuitBreaker(settings CircuitSettings) (*CircuitBreaker, error) {
+	if err := validateSettings(settings); err != nil {
+		return nil, err
+	}
+	return newCircuitBreaker(settings), nil
+}
 
+// validateSettings tells what is wrong with the settings spec, if anything
+func validateSettings(settings CircuitSettings) error {
+	if settings.Timeout < 0 {
+		return fmt.Errorf("Timeout must not be negative but it is %s", settings.Timeout)
+	}
+	if settings.RetryTimePeriod < 0 {
+		return fmt.Errorf("RetryTimePeriod must not be negative but it is %s", settings.RetryTimePeriod)
+	}
+	if settings.InitialRetryPeriod < 0 {
+		return fmt.Errorf("InitialRetryPeriod must not be negative but it is %s", settings.InitialRetryPeriod)
+	}
+	if settings.FailureThreshold < 0 {
+		return fmt.Errorf("FailureThreshold must be at least 1 but it is %d", settings.FailureThreshold)
+	}
+	if settings.WindowDuration < 0 {
+		return fmt.Errorf("WindowDuration must not be negative but it is %s", settings.WindowDuration)
+	}
+	if settings.ErrorPercentThreshold < 0 || settings.ErrorPercentThreshold >= 100 {
+		return fmt.Errorf("ErrorPercentThreshold must be from 0 to 99 but it is %d", settings.ErrorPercentThreshold)
+	}
+	if settings.SlowCallRateThreshold < 0 || settings.SlowCallRateThreshold >= 100 {
+		return fmt.Errorf("SlowCallRateThreshold must be from 0 to 99 but it is %d", settings.SlowCallRateThreshold)
+	}
+	if settings.RollingWindowSize < 0 {
+		return fmt.Errorf("RollingWindowSize must not be negative but it is %d", settings.RollingWindowSize)
+	}
+	if settings.MinRequestVolume < 0 {
+		return fmt.Errorf("MinRequestVolume must not be negative but it is %d", settings.MinRequestVolume)
+	}
+	if settings.ThresholdFunc != nil && settings.RollingWindowSize == 0 {
+		return fmt.Errorf("ThresholdFunc needs a RollingWindowSize to tell how many requests are recent")
+	}
+	if settings.MaxFailureRecords < 0 {
+		return fmt.Errorf("MaxFailureRecords must not be negative but it is %d", settings.MaxFailureRecords)
+	}
+	if settings.MaxHistory < 0 {
+		return fmt.Errorf("MaxHistory must not be negative but it is %d", settings.MaxHistory)
+	}
+	if settings.InitialState != 0 && settings.InitialState != IsClosed && settings.InitialState != IsOpen {
+		return fmt.Errorf("InitialState must be either closed or open but it is %s", settings.InitialState.ToString())
+	}
+	return nil
+}
+
+// withDefaults fills in whatever the settings spec leaves as zero
+func withDefaults(settings CircuitSettings) CircuitSettings {
 	if settings.Timeout == 0 {
 		settings.Timeout = DefautTimeout
 	}
@@ -109,22 +496,161 @@ func NewCircuitBreaker(settings CircuitSettings) (*CircuitBreaker, error) {
 	if settings.FailureThreshold == 0 {
 		settings.FailureThreshold = DefautlFailureThreshold
 	}
+	if settings.SuccessThreshold == 0 {
+		settings.SuccessThreshold = DefaultSuccessThreshold
+	}
+	if settings.MaxFailureRecords == 0 {
+		settings.MaxFailureRecords = DefaultMaxFailureRecords
+	}
+	if settings.MaxHistory == 0 {
+		settings.MaxHistory = DefaultMaxHistory
+	}
+	if settings.Clock == nil {
+		settings.Clock = realClock{}
+	}
+	if settings.ClockResolution == 0 {
+		settings.ClockResolution = DefaultClockResolution
+	}
+	if settings.RandSource == nil {
+		settings.RandSource = rand.NewSource(settings.Clock.Now().UnixNano())
+	}
+	return settings
+}
+
+// newCircuitBreaker builds a circuit breaker which may have no service of its
+// own, for whoever gives it something to call on every call
+func newCircuitBreaker(settings CircuitSettings) *CircuitBreaker {
+	settings = withDefaults(settings)
 
 	cb := &CircuitBreaker{
 		Settings:        settings,
 		LastFailureTime: time.Time{},
 		FailureCount:    0,
 		FailureRecord:   []string{},
+		FailureTimes:    []time.Time{},
+		lastState:       IsClosed,
+		random:          rand.New(settings.RandSource),
+		clock:           settings.Clock,
+	}
+	if settings.MaxConcurrentCalls > 0 {
+		cb.bulkhead = make(chan struct{}, settings.MaxConcurrentCalls)
+	}
+	if settings.InitialState == IsOpen {
+		// As if it had just tripped, but nobody is told about it
+		cb.padFailures()
+		cb.LastFailureTime = cb.clock.Now()
+		cb.shuffleRetryJitter()
+		cb.lastState = IsOpen
+	}
+	if settings.StateStore != nil {
+		cb.loadState()
+	}
+	if settings.Logger != nil && settings.RetryTimePeriod < settings.ClockResolution {
+		// It works all the same, just not as finely as it was asked for
+		cb.logAttrs(slog.LevelWarn, "Retry time period is below clock resolution",
+			slog.Duration("retry_time_period", settings.RetryTimePeriod),
+			slog.Duration("clock_resolution", settings.ClockResolution))
 	}
-	return cb, nil
+	return cb
+}
+
+// Clone builds a circuit breaker with the very same settings, callbacks
+// included, but none of the state, e.g. one per host out of a template. It
+// has no StateStore though, since the one there keeps the original's state.
+func (cb *CircuitBreaker) Clone() *CircuitBreaker {
+	cb.mutex.Lock()
+	settings := cb.Settings
+	// Sources of randomness are not meant to be shared
+	settings.RandSource = rand.NewSource(cb.random.Int63())
+	cb.mutex.Unlock()
+	settings.StateStore = nil
+
+	return newCircuitBreaker(settings)
 }
 
 // State reflects the most up to date state of circuit
 func (cb *CircuitBreaker) State() CircuitState {
-	if cb.FailureCount >= cb.Settings.FailureThreshold {
+	cb.forgetIfIdle()
+
+	cb.mutex.RLock()
+	defer cb.mutex.RUnlock()
+	return cb.state()
+}
+
+// CurrentFailureCount tells how many times the service failed, safe to be
+// called while there are calls on their way
+func (cb *CircuitBreaker) CurrentFailureCount() int {
+	cb.mutex.RLock()
+	defer cb.mutex.RUnlock()
+	return cb.FailureCount
+}
+
+// FailuresUntilTrip tells how many more fails would trip the circuit, e.g.
+// to warn about it being degraded. It is zero when open already and one when
+// half-open, as a single missed chance is enough to open it again. With
+// ErrorPercentThreshold, it is how many fails in a row would get the window
+// over it.
+func (cb *CircuitBreaker) FailuresUntilTrip() int {
+	cb.mutex.RLock()
+	defer cb.mutex.RUnlock()
+
+	switch cb.state() {
+	case IsOpen:
+		return 0
+	case IsHalfOpen:
+		return 1
+	}
+	if cb.percentageMode() {
+		return cb.failuresUntilOverErrorPercent()
+	}
+	// Only the fails within the window count
+	return max(0, cb.failureThreshold()-(cb.FailureCount-cb.staleFailures()))
+}
+
+// Snapshot gives a consistent view of the circuit as it is, e.g. for
+// debugging, and unlike State it never changes anything on its way
+func (cb *CircuitBreaker) Snapshot() (state CircuitState, failures int, lastFailure time.Time) {
+	cb.mutex.RLock()
+	defer cb.mutex.RUnlock()
+	return cb.state(), cb.FailureCount, cb.LastFailureTime
+}
+
+// Healthy tells whether the circuit is closed, e.g. for a readiness probe
+func (cb *CircuitBreaker) Healthy() bool {
+	return cb.State() == IsClosed
+}
+
+// TimeUntilHalfOpen tells how long until the service gets a chance again,
+// e.g. for a Retry-After header. It is zero unless the circuit is open, and
+// when it is forced open too, since there is no telling when.
+func (cb *CircuitBreaker) TimeUntilHalfOpen() time.Duration {
+	cb.mutex.RLock()
+	defer cb.mutex.RUnlock()
+
+	if cb.forcedOpen || cb.state() != IsOpen {
+		return 0
+	}
+	return max(cb.retryTimePeriod()-cb.clock.Now().Sub(cb.LastFailureTime), 0)
+}
+
+// TimeoutCount tells how many of the fails since last time it was cool were
+// timeouts, so that a slow service can be told apart from a broken one
+func (cb *CircuitBreaker) TimeoutCount() int {
+	cb.mutex.RLock()
+	defer cb.mutex.RUnlock()
+	return cb.timeoutCount
+}
+
+// state must be called with the lock held
+func (cb *CircuitBreaker) state() CircuitState {
+	if cb.forcedOpen {
+		// Somebody wants it open no matter what, e.g. a maintenance window
+		return IsOpen
+	}
+	if cb.tripped() {
 		// When it has already faild too much, we should do something
-		gracePeriod := time.Now().Sub(cb.LastFailureTime) * time.Millisecond
-		if gracePeriod > cb.Settings.RetryTimePeriod {
+		gracePeriod := cb.clock.Now().Sub(cb.LastFailureTime)
+		if gracePeriod > cb.retryTimePeriod() {
 			// In this case, we can give it a chance
 			return IsHalfOpen
 		}
@@ -135,131 +661,1110 @@ func (cb *CircuitBreaker) State() CircuitState {
 	return IsClosed
 }
 
+// tripped must be called with the lock held
+func (cb *CircuitBreaker) tripped() bool {
+	if cb.slowCallMode() && cb.enoughVolume() {
+		slowCalls := 0
+		for _, slow := range cb.slowOutcomes {
+			if slow {
+				slowCalls++
+			}
+		}
+		// Too slow to be of any use is not far from failing
+		if slowCalls*100 > cb.Settings.SlowCallRateThreshold*len(cb.slowOutcomes) {
+			return true
+		}
+	}
+	if cb.failingTooLong() {
+		// It has been failing for long enough, however few fails it took
+		return true
+	}
+	if cb.percentageMode() {
+		return cb.overErrorPercent(cb.outcomes)
+	}
+	// Reaching the threshold is enough, there is no need to go past it
+	return cb.FailureCount-cb.staleFailures() >= cb.failureThreshold()
+}
+
+// retryTimePeriod must be called with the lock held
+func (cb *CircuitBreaker) retryTimePeriod() time.Duration {
+	return max(cb.backoffRetryTimePeriod()+cb.retryJitter, cb.Settings.ClockResolution)
+}
+
+// backoffRetryTimePeriod must be called with the lock held
+func (cb *CircuitBreaker) backoffRetryTimePeriod() time.Duration {
+	period := cb.Settings.RetryTimePeriod
+	if cb.Settings.InitialRetryPeriod > 0 {
+		period = cb.Settings.InitialRetryPeriod
+		if cb.Settings.MaxRetryTimePeriod > 0 {
+			// Wherever it starts from, it is still no longer than that
+			period = min(period, cb.Settings.MaxRetryTimePeriod)
+		}
+	}
+	if cb.Settings.BackoffMultiplier <= 0 {
+		if cb.failedProbes > 0 {
+			// Without backoff, it is only the first time that is any different
+			return cb.Settings.RetryTimePeriod
+		}
+		return period
+	}
+	// The more chances it misses, the longer it waits for the next one
+	for i := 0; i < cb.failedProbes; i++ {
+		period = time.Duration(float64(period) * cb.Settings.BackoffMultiplier)
+		if cb.Settings.MaxRetryTimePeriod > 0 && period >= cb.Settings.MaxRetryTimePeriod {
+			return cb.Settings.MaxRetryTimePeriod
+		}
+	}
+	return period
+}
+
+// shuffleRetryJitter must be called with the lock held
+func (cb *CircuitBreaker) shuffleRetryJitter() {
+	jitter := int64(cb.Settings.RetryJitter)
+	if jitter <= 0 {
+		cb.retryJitter = 0
+		return
+	}
+	// Anywhere from -jitter to +jitter
+	cb.retryJitter = time.Duration(cb.random.Int63n(2*jitter+1) - jitter)
+}
+
+func (cb *CircuitBreaker) percentageMode() bool {
+	return cb.Settings.ErrorPercentThreshold > 0 && cb.Settings.RollingWindowSize > 0
+}
+
+func (cb *CircuitBreaker) slowCallMode() bool {
+	return cb.Settings.SlowCallThreshold > 0 && cb.Settings.SlowCallRateThreshold > 0 && cb.Settings.RollingWindowSize > 0
+}
+
+// enoughVolume must be called with the lock held
+func (cb *CircuitBreaker) enoughVolume() bool {
+	return len(cb.outcomes) >= cb.minRequestVolume()
+}
+
+func (cb *CircuitBreaker) minRequestVolume() int {
+	volume := cb.Settings.MinRequestVolume
+	if volume == 0 || volume > cb.Settings.RollingWindowSize {
+		volume = cb.Settings.RollingWindowSize
+	}
+	return volume
+}
+
+// overErrorPercent must be called with the lock held
+func (cb *CircuitBreaker) overErrorPercent(outcomes []bool) bool {
+	// Until there are enough calls it is too soon to tell
+	if len(outcomes) < cb.minRequestVolume() {
+		return false
+	}
+	failures := 0
+	for _, success := range outcomes {
+		if !success {
+			failures++
+		}
+	}
+	return failures*100 > cb.Settings.ErrorPercentThreshold*len(outcomes)
+}
+
+// failuresUntilOverErrorPercent must be called with the lock held
+func (cb *CircuitBreaker) failuresUntilOverErrorPercent() int {
+	// Play the next fails on a copy of the window, just like recordOutcome
+	size := cb.Settings.RollingWindowSize
+	outcomes := append([]bool(nil), cb.outcomes...)
+	index := cb.outcomeIndex
+	for n := 1; n <= size; n++ {
+		if len(outcomes) < size {
+			outcomes = append(outcomes, false)
+		} else {
+			outcomes[index] = false
+			index = (index + 1) % size
+		}
+		if cb.overErrorPercent(outcomes) {
+			return n
+		}
+	}
+	// A whole window of fails would not do it either
+	return size
+}
+
 // Call is the circuit break safe call to a service.
 // Returns:
 // - Service actual response content;
 // - True if relying on fallback, False otherwise;
 // - An error or nil otherwise.
 func (cb *CircuitBreaker) Call() (interface{}, bool, error) {
+	return cb.CallContext(context.Background())
+}
+
+// CallContext is the same as Call but the service call is abandoned as soon
+// as the given context is done, e.g. when an HTTP client goes away.
+func (cb *CircuitBreaker) CallContext(ctx context.Context) (interface{}, bool, error) {
+	return cb.call(ctx, nil, 0)
+}
+
+// CallWithTimeout is the same as Call but the service has the given timeout
+// to respond, just this time. Zero means the configured Timeout.
+func (cb *CircuitBreaker) CallWithTimeout(timeout time.Duration) (interface{}, bool, error) {
+	return cb.call(context.Background(), nil, timeout)
+}
+
+// CallWithCancel is the same as Call but the service call is abandoned as
+// soon as done is closed, for callers who don't go by context
+func (cb *CircuitBreaker) CallWithCancel(done <-chan struct{}) (interface{}, bool, error) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+
+	go func() {
+		select {
+		case <-done:
+			cancel(ErrCallCancelled)
+		case <-ctx.Done():
+			// The call is over, so there is nothing to cancel anymore
+		}
+	}()
+	return cb.call(ctx, nil, 0)
+}
+
+// Execute is the same as Call but for the given operation instead of the
+// configured Service, so that many operations on the same downstream may
+// share one circuit.
+func (cb *CircuitBreaker) Execute(op Callable) (interface{}, bool, error) {
+	return cb.call(context.Background(), op, 0)
+}
+
+// Protect gives the operation back as it goes through the circuit, so that
+// it fits wherever a Callable does, e.g. the service of yet another circuit.
+// Whether it was fallbacked is left out, though a fallback still comes with
+// its error unless TreatFallbackAsSuccess says otherwise.
+func (cb *CircuitBreaker) Protect(op Callable) Callable {
+	return func() (interface{}, error) {
+		res, _, err := cb.Execute(op)
+		return res, err
+	}
+}
+
+// ExecuteAll runs the given operations one after the other through the
+// circuit, e.g. once it trips the rest of them get the fallback right away.
+// Results come in the same order.
+func (cb *CircuitBreaker) ExecuteAll(ops []Callable) []CallResult {
+	results := make([]CallResult, 0, len(ops))
+	for _, op := range ops {
+		res, fallbacked, err := cb.Execute(op)
+		results = append(results, CallResult{res, fallbacked, err})
+	}
+	return results
+}
+
+func (cb *CircuitBreaker) call(ctx context.Context, service Callable, timeout time.Duration) (interface{}, bool, error) {
+	res, fallbacked, _, err := cb.callWithReason(ctx, service, timeout)
+	return res, fallbacked, err
+}
+
+// callWithReason calls the given service, or the configured one when nil
+func (cb *CircuitBreaker) callWithReason(ctx context.Context, service Callable, timeout time.Duration) (interface{}, bool, FallbackReason, error) {
+	// A single look at the settings for the whole call, so that Configure
+	// may change them in the meantime
+	settings := cb.CurrentSettings()
+	if service == nil {
+		service = settings.Service
+	}
+
+	if cb.isShutdown() {
+		return nil, false, ReasonNone, cb.named(&settings, ErrClosed)
+	}
+
+	cb.countCall()
+
 	// What is the current state pre call to service
-	preState := cb.State()
+	preState := cb.refreshState()
+	if preState == IsHalfOpen {
+		if cb.acquireHalfOpenCall() {
+			defer cb.releaseHalfOpenCall()
+		} else {
+			// Enough calls are giving it a chance already, so as far as
+			// this one is concerned the circuit is still open
+			preState = IsOpen
+		}
+	}
+	if preState != IsOpen && !settings.ShadowMode {
+		if !cb.acquireBulkhead() {
+			// Too many calls are on their way already, so this one doesn't
+			// even try nor it says anything about the service health
+			return cb.rejectCall(ctx, &settings, ErrBulkheadFull, "full bulkhead")
+		}
+		defer cb.releaseBulkhead()
+
+		if !cb.takeCallTurn() {
+			// The service was called a moment ago, so this one has to wait
+			// its turn, and just like above it says nothing about its health
+			return cb.rejectCall(ctx, &settings, ErrCallTooSoon, "call rate")
+		}
+	}
 
-	res, fallbacked, err := cb.selectiveCall(preState)
-	if fallbacked {
-		// When we get a fallback, it means we got an error at some point
-		cb.recordFailure(err)
-	} else {
+	res, fallbacked, latency, err := cb.selectiveCall(ctx, &settings, preState, service, timeout)
+
+	// Only one caller at a time gets to update the circuit and notify about it
+	cb.eventMutex.Lock()
+	defer cb.eventMutex.Unlock()
+
+	switch {
+	case preState == IsOpen:
+		// The service was not even called, or in shadow mode it would not
+		// have been, so there is nothing new to learn about its health
+		if settings.OnReject != nil {
+			settings.OnReject()
+		}
+	case fallbacked, serviceFailed(err):
+		// When we get a fallback, it means we got an error at some point, and
+		// with no fallback at all the error is right there. Either way it is
+		// no chance taken by the service, even if the fallback is treated as
+		// a success, since it goes by the error before that.
+		cb.recordFailure(preState, err)
+		cb.notifyFailure(err)
+	default:
 		// If we're not dealing with a fallback, it means everything is good
-		// and we can reset circuit state
-		cb.resetState()
+		// and we can eventually reset circuit state
+		cb.recordSuccess(preState, cb.slowCall(&settings, err, latency))
+		if err == nil {
+			cb.notifySuccess(latency)
+		}
 	}
 
 	// After all we look at state again because it might be require for a change
-	newState := cb.State()
-	cb.notifyState(preState, newState)
+	cb.notifyState(cb.State())
 
-	return res, fallbacked, err
+	reason := fallbackReason(fallbacked, err)
+	err = cb.acceptFallback(&settings, fallbacked, err)
+	if !settings.ShadowMode && (preState == IsOpen || fallbacked || serviceFailed(err)) {
+		return res, fallbacked, reason, cb.circuitError(&settings, err)
+	}
+	// Whatever the service said that is no fail is up to the caller
+	return res, fallbacked, reason, cb.named(&settings, err)
+}
+
+// named prefixes the error with the circuit name, if any
+func (cb *CircuitBreaker) named(settings *CircuitSettings, err error) error {
+	if err == nil || settings.Name == "" {
+		return err
+	}
+	return fmt.Errorf("[%s] %w", settings.Name, err)
+}
+
+// circuitError tells how the circuit is along with the error, if any
+func (cb *CircuitBreaker) circuitError(settings *CircuitSettings, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &CircuitError{
+		Name:         settings.Name,
+		State:        cb.State(),
+		FailureCount: cb.CurrentFailureCount(),
+		Cause:        err,
+	}
+}
+
+// acceptFallback lets go of the error of a fallback that made it, if asked to
+func (cb *CircuitBreaker) acceptFallback(settings *CircuitSettings, fallbacked bool, err error) error {
+	var fallbackedErr *fallbackedError
+	if fallbacked && settings.TreatFallbackAsSuccess && errors.As(err, &fallbackedErr) {
+		return nil
+	}
+	return err
+}
+
+// slowCall tells whether a call made it, but way too slowly
+func (cb *CircuitBreaker) slowCall(settings *CircuitSettings, err error, latency time.Duration) bool {
+	return err == nil && settings.SlowCallThreshold > 0 && latency > settings.SlowCallThreshold
+}
+
+// serviceFailed tells whether a call without fallback has failed, since it
+// is as much of a fail as one with a fallback
+func serviceFailed(err error) bool {
+	var callingErr *CallingError
+	return errors.As(err, &callingErr)
+}
+
+func (cb *CircuitBreaker) acquireHalfOpenCall() bool {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	if cb.Settings.HalfOpenMaxCalls > 0 && cb.halfOpenCalls >= cb.Settings.HalfOpenMaxCalls {
+		return false
+	}
+	cb.halfOpenCalls = cb.halfOpenCalls + 1
+	return true
+}
+
+func (cb *CircuitBreaker) releaseHalfOpenCall() {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	cb.halfOpenCalls = cb.halfOpenCalls - 1
+}
+
+func (cb *CircuitBreaker) acquireBulkhead() bool {
+	if cb.bulkhead == nil {
+		return true
+	}
+	select {
+	case cb.bulkhead <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (cb *CircuitBreaker) releaseBulkhead() {
+	if cb.bulkhead != nil {
+		<-cb.bulkhead
+	}
+}
+
+// rejectCall goes for the fallback in place of the service, due to the given
+// cause, e.g. "full bulkhead"
+func (cb *CircuitBreaker) rejectCall(ctx context.Context, settings *CircuitSettings, cause error, due string) (interface{}, bool, FallbackReason, error) {
+	res, fallbacked, err := cb.mayCallFallback(ctx, settings, cause)
+	reason := fallbackReason(fallbacked, cause)
+	if !fallbacked {
+		if err != nil {
+			return nil, false, reason, cb.circuitError(settings, fmt.Errorf("%w: %w", cause, err))
+		}
+		return nil, false, reason, cb.circuitError(settings, fmt.Errorf("%w: %w", cause, ErrNoFallback))
+	}
+	if err != nil {
+		return res, fallbacked, reason, cb.circuitError(settings, fmt.Errorf("Service was fallbacked due to %s but failed too: %w: %w", due, err, cause))
+	}
+	return res, fallbacked, reason, cb.circuitError(settings, cb.acceptFallback(settings, fallbacked, &fallbackedError{fmt.Errorf("Service was fallbacked due to %s: %w", due, cause)}))
+}
+
+// takeCallTurn tells whether the service may be called already, as far as
+// MinCallInterval is concerned, and if so it is its turn from now on
+func (cb *CircuitBreaker) takeCallTurn() bool {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	if cb.Settings.MinCallInterval == 0 {
+		return true
+	}
+
+	now := cb.clock.Now()
+	if !cb.lastServiceCall.IsZero() && now.Sub(cb.lastServiceCall) < cb.Settings.MinCallInterval {
+		return false
+	}
+	cb.lastServiceCall = now
+	return true
+}
+
+// refreshState notifies about any change that happened on its own since the
+// last call, e.g. an open circuit that became half-open as time went by
+func (cb *CircuitBreaker) refreshState() CircuitState {
+	cb.eventMutex.Lock()
+	defer cb.eventMutex.Unlock()
+
+	state := cb.State()
+	cb.notifyState(state)
+	return state
+}
+
+// Failures gives a copy of the failures since last time it was cool
+func (cb *CircuitBreaker) Failures() []FailureEntry {
+	cb.mutex.RLock()
+	defer cb.mutex.RUnlock()
+
+	failures := make([]FailureEntry, len(cb.FailureRecord))
+	for i, err := range cb.FailureRecord {
+		failures[i] = FailureEntry{Time: cb.FailureTimes[i], Err: err}
+	}
+	return failures
 }
 
-func (cb *CircuitBreaker) selectiveCall(state CircuitState) (interface{}, bool, error) {
+// GroupedFailures is the same as Failures but the ones in a row with the
+// same error are collapsed into one, e.g. a service that keeps timing out
+func (cb *CircuitBreaker) GroupedFailures() []FailureGroup {
+	cb.mutex.RLock()
+	defer cb.mutex.RUnlock()
+
+	groups := []FailureGroup{}
+	for i, err := range cb.FailureRecord {
+		if last := len(groups) - 1; last >= 0 && groups[last].Err == err {
+			groups[last].Count++
+			groups[last].LastSeen = cb.FailureTimes[i]
+			continue
+		}
+		groups = append(groups, FailureGroup{Err: err, Count: 1, LastSeen: cb.FailureTimes[i]})
+	}
+	return groups
+}
+
+// Trip forces the circuit open right away, no matter how the service is doing
+func (cb *CircuitBreaker) Trip() {
+	cb.eventMutex.Lock()
+	defer cb.eventMutex.Unlock()
+
+	cb.mutex.Lock()
+	cb.pruneFailures()
+	cb.padFailures()
+	cb.SuccessCount = 0
+	cb.LastFailureTime = cb.clock.Now()
+	cb.shuffleRetryJitter()
+	cb.mutex.Unlock()
+
+	cb.notifyState(cb.State())
+}
+
+// padFailures must be called with the lock held
+func (cb *CircuitBreaker) padFailures() {
+	cb.FailureCount = max(cb.FailureCount, cb.failureThreshold())
+	if cb.percentageMode() {
+		// Percentage wise, it looks as bad as it gets
+		for i := 0; i < cb.Settings.RollingWindowSize; i++ {
+			cb.recordOutcome(false, false)
+		}
+	}
+}
+
+// Reset forces the circuit closed right away, forgetting about past failures
+func (cb *CircuitBreaker) Reset() {
+	cb.eventMutex.Lock()
+	defer cb.eventMutex.Unlock()
+
+	cb.resetState()
+	cb.mutex.Lock()
+	cb.clearOutcomes()
+	cb.mutex.Unlock()
+
+	cb.notifyState(cb.State())
+}
+
+// ForceOpen keeps the circuit open, never giving the service a chance, until
+// ClearForced is called. It is meant for planned downstream maintenance.
+func (cb *CircuitBreaker) ForceOpen() {
+	cb.setForcedOpen(true)
+}
+
+// ClearForced lets the circuit follow the service health again
+func (cb *CircuitBreaker) ClearForced() {
+	cb.setForcedOpen(false)
+}
+
+func (cb *CircuitBreaker) isForcedOpen() bool {
+	cb.mutex.RLock()
+	defer cb.mutex.RUnlock()
+	return cb.forcedOpen
+}
+
+func (cb *CircuitBreaker) setForcedOpen(forced bool) {
+	cb.eventMutex.Lock()
+	defer cb.eventMutex.Unlock()
+
+	cb.mutex.Lock()
+	cb.forcedOpen = forced
+	cb.mutex.Unlock()
+
+	cb.notifyState(cb.State())
+}
+
+func (cb *CircuitBreaker) selectiveCall(ctx context.Context, settings *CircuitSettings, state CircuitState, service Callable, timeout time.Duration) (interface{}, bool, time.Duration, error) {
+	if state == IsHalfOpen && timeout == 0 && settings.HalfOpenTimeout > 0 {
+		// A service that is still down should not take long to tell
+		timeout = settings.HalfOpenTimeout
+	}
+	if settings.ShadowMode {
+		// Whatever the state, it is not for real
+		res, latency, err := cb.callService(ctx, settings, service, timeout)
+		return res, false, latency, err
+	}
 	switch state {
 	case IsOpen:
+		if settings.FailFast {
+			// There is nothing worth waiting for
+			return nil, false, 0, ErrCircuitOpen
+		}
 		// When open, use the fallback function, we might rely on cache or something
-		res, fallbacked, err := cb.mayCallFallback()
+		res, fallbacked, err := cb.mayCallFallback(ctx, settings, ErrCircuitOpen)
+		if !fallbacked {
+			if err != nil {
+				return nil, false, 0, fmt.Errorf("%w: %w", ErrCircuitOpen, err)
+			}
+			return nil, false, 0, fmt.Errorf("%w: %w", ErrCircuitOpen, ErrNoFallback)
+		}
 		if err != nil {
-			return res, fallbacked, fmt.Errorf("Service was fallbacked due to open state but failed too: %s", err.Error())
+			return res, fallbacked, 0, fmt.Errorf("Service was fallbacked due to open state but failed too: %w: %w", err, ErrCircuitOpen)
 		}
-		return res, fallbacked, fmt.Errorf("Service was fallbacked due to open state")
+		return res, fallbacked, 0, &fallbackedError{fmt.Errorf("Service was fallbacked due to open state: %w", ErrCircuitOpen)}
 	case IsHalfOpen:
+		if settings.ProbeWithoutFallback {
+			// The caller wants to know how the service is really doing
+			res, latency, err := cb.callService(ctx, settings, service, timeout)
+			return res, false, latency, err
+		}
 		// When it is this state we call give it a one chance to go
 		fallthrough
 	case IsClosed:
 		// This function calls the service within a timeout restrict time
-		res, err := cb.callService()
+		res, latency, err := cb.callService(ctx, settings, service, timeout)
+		if _, failed := err.(*CallingError); err != nil && !failed {
+			// It is not the service's fault, so the caller gets it as it is
+			return nil, false, latency, err
+		}
 		if err != nil {
 			// In case of any error, we go for a possible fallback
-			res, fallbacked, fberr := cb.mayCallFallback()
+			res, fallbacked, fberr := cb.mayCallFallback(ctx, settings, err)
 			if fallbacked {
 				if fberr != nil {
 					// Even the fallback may get an error
-					return res, fallbacked, fmt.Errorf("Service was fallbacked due to error but failed too: %s: %s", fberr.Error(), err.Error())
+					return res, fallbacked, latency, fmt.Errorf("Service was fallbacked due to error but failed too: %w: %w", fberr, err)
 				}
-				return res, fallbacked, fmt.Errorf("Service was fallbacked due to error: %s", err.Error())
+				return res, fallbacked, latency, &fallbackedError{fmt.Errorf("Service was fallbacked due to error: %w", err)}
+			}
+			if fberr != nil {
+				// The fallback was skipped, the service failed all the same
+				return nil, false, latency, fmt.Errorf("%w: %w", err, fberr)
 			}
-			return res, false, err
+			return res, false, latency, fmt.Errorf("%w: %w", err, ErrNoFallback)
 		}
 		// Damn! We made it. Everything is fresh and cool
-		return res, false, err
+		return res, false, latency, err
 	default:
-		return nil, false, fmt.Errorf("Unknown state")
+		return nil, false, 0, fmt.Errorf("Unknown state")
 	}
 }
 
-func (cb *CircuitBreaker) callService() (interface{}, error) {
+// callService also tells how long it took, whatever the outcome
+func (cb *CircuitBreaker) callService(ctx context.Context, settings *CircuitSettings, service Callable, timeout time.Duration) (interface{}, time.Duration, error) {
+	if timeout == 0 {
+		timeout = settings.Timeout
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		// No point in waiting any longer than whoever asked for it
+		if until := time.Until(deadline); timeout == 0 || until < timeout {
+			timeout = until
+		}
+	}
+
+	start := time.Now()
+	res, err := cb.waitService(ctx, settings, service, timeout)
+	latency := time.Since(start)
+	cb.recordLatency(latency)
+	return res, latency, err
+}
+
+func runService(service Callable) (res callableResponse) {
+	defer func() {
+		if r := recover(); r != nil {
+			// A service that panics is nothing but a failing one
+			res = callableResponse{nil, fmt.Errorf("Service panicked: %v", r)}
+		}
+	}()
+
+	content, err := service()
+	return callableResponse{content, err}
+}
+
+func (cb *CircuitBreaker) waitService(ctx context.Context, settings *CircuitSettings, service Callable, timeout time.Duration) (interface{}, error) {
+	if ctx.Err() != nil {
+		// Whoever asked for it does not care anymore, so why bother
+		return nil, &CallingError{context.Cause(ctx)}
+	}
+
+	noTimeout := cb.synchronous && timeout == 0
+	if noTimeout && ctx.Done() == nil {
+		// There is nothing to watch, so there is no need for a goroutine
+		return cb.serviceResponse(settings, runService(service))
+	}
+
+	var timedOut <-chan time.Time
+	if !noTimeout {
+		timedOut = time.After(timeout)
+	}
+
 	responseChannel := make(chan callableResponse, 1)
 
 	go func() {
-		res, err := cb.Settings.Service()
-		responseChannel <- callableResponse{res, err}
+		responseChannel <- runService(service)
 	}()
 
 	select {
 	case res := <-responseChannel:
-		if res.Error != nil {
-			return nil, &CallingError{res.Error}
+		return cb.serviceResponse(settings, res)
+	case <-timedOut:
+		cb.mutex.Lock()
+		select {
+		case res := <-responseChannel:
+			cb.mutex.Unlock()
+			// It responded right at the last moment, so it is no timeout after all
+			return cb.serviceResponse(settings, res)
+		default:
 		}
-		if res.Content == nil {
-			err := fmt.Errorf("Service respond is nil")
-			return nil, &CallingError{err}
+		cb.metrics.TotalTimeouts++
+		cb.timeoutCount++
+		cb.mutex.Unlock()
+
+		err := fmt.Errorf("%w after %d milliseconds", ErrServiceTimeout, timeout.Milliseconds())
+		return nil, &CallingError{err}
+	case <-ctx.Done():
+		// Whoever asked for it does not care anymore
+		return nil, &CallingError{context.Cause(ctx)}
+	}
+}
+
+func (cb *CircuitBreaker) serviceResponse(settings *CircuitSettings, res callableResponse) (interface{}, error) {
+	if settings.IsSuccess != nil {
+		if settings.IsSuccess(res.Content, res.Error) {
+			// Whatever it is, the caller gets it as it is
+			return res.Content, res.Error
 		}
-		return res.Content, nil
-	case <-time.After(time.Duration(cb.Settings.Timeout) * time.Millisecond):
-		err := fmt.Errorf("Service timed out after %d milliseconds", cb.Settings.Timeout)
+		if res.Error == nil {
+			return nil, &CallingError{fmt.Errorf("Service respond is not a success")}
+		}
+		return nil, &CallingError{res.Error}
+	}
+	if res.Error != nil {
+		if settings.IsFailure != nil && !settings.IsFailure(res.Error) {
+			return nil, res.Error
+		}
+		return nil, &CallingError{res.Error}
+	}
+	if res.Content == nil && !settings.AllowNilResponse {
+		err := fmt.Errorf("Service respond is nil")
 		return nil, &CallingError{err}
 	}
+	return res.Content, nil
 }
 
-func (cb *CircuitBreaker) mayCallFallback() (interface{}, bool, error) {
-	if cb.Settings.Fallback == nil {
+func (cb *CircuitBreaker) mayCallFallback(ctx context.Context, settings *CircuitSettings, cause error) (interface{}, bool, error) {
+	if err := cb.outOfTime(ctx, settings); err != nil {
+		// The caller would be gone before the fallback is done anyway
+		return nil, false, err
+	}
+	fallbacks := cb.fallbacks(ctx, settings, cause)
+	if len(fallbacks) == 0 {
 		return nil, false, nil
 	}
 	// So ok, we have a fallback and we're going to rely on it
-	res, err := cb.Settings.Fallback()
-	return res, true, err
+	res, err := cb.callFallback(settings, fallbacks[0])
+	if err == nil || len(fallbacks) == 1 {
+		return res, true, err
+	}
+	// And on the next ones, if it comes to that
+	errs := []error{fmt.Errorf("Fallback 1 of %d failed: %w", len(fallbacks), err)}
+	for i, fallback := range fallbacks[1:] {
+		res, err = cb.callFallback(settings, fallback)
+		if err == nil {
+			return res, true, nil
+		}
+		errs = append(errs, fmt.Errorf("Fallback %d of %d failed: %w", i+2, len(fallbacks), err))
+	}
+	return res, true, errors.Join(errs...)
+}
+
+// fallbacks gives the chain of fallbacks, in the order they are tried
+func (cb *CircuitBreaker) fallbacks(ctx context.Context, settings *CircuitSettings, cause error) []Callable {
+	var fallbacks []Callable
+	if settings.FallbackWithCause != nil {
+		// This one wants to know why it is being called
+		fallbacks = append(fallbacks, func() (interface{}, error) {
+			return settings.FallbackWithCause(cause)
+		})
+	} else if settings.FallbackWithMeta != nil {
+		// And this one has something to tell, if anyone is listening
+		fallbacks = append(fallbacks, func() (interface{}, error) {
+			res, meta, err := settings.FallbackWithMeta()
+			if err == nil {
+				keepMeta(ctx, meta)
+			}
+			return res, err
+		})
+	} else if settings.Fallback != nil {
+		fallbacks = append(fallbacks, settings.Fallback)
+	}
+	return append(fallbacks, settings.Fallbacks...)
+}
+
+// outOfTime tells why there is no point in calling the fallback, if so
+func (cb *CircuitBreaker) outOfTime(ctx context.Context, settings *CircuitSettings) error {
+	if settings.FallbackMinTime == 0 {
+		return nil
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok || time.Until(deadline) >= settings.FallbackMinTime {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return context.DeadlineExceeded
+}
+
+func (cb *CircuitBreaker) callFallback(settings *CircuitSettings, fallback Callable) (res interface{}, err error) {
+	cb.mutex.Lock()
+	cb.metrics.TotalFallbacks++
+	cb.mutex.Unlock()
+
+	if settings.MetricsHook != nil {
+		settings.MetricsHook.Fallbacked()
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			// A fallback that panics is nothing but a failing one
+			res, err = nil, fmt.Errorf("Fallback panicked: %v", r)
+		}
+	}()
+	return fallback()
+}
+
+func (cb *CircuitBreaker) recordSuccess(state CircuitState, slow bool) {
+	cb.mutex.Lock()
+	cb.metrics.TotalSuccesses++
+	cb.recordOutcome(true, slow)
+	if state == IsClosed && cb.tripped() {
+		// Even so, it just completed a window with too many failures
+		cb.LastFailureTime = cb.clock.Now()
+		cb.shuffleRetryJitter()
+		cb.mutex.Unlock()
+		return
+	}
+	if state == IsHalfOpen {
+		cb.SuccessCount = cb.SuccessCount + 1
+		if cb.SuccessCount < cb.Settings.SuccessThreshold {
+			// Not enough successful probes in a row to trust it yet
+			cb.mutex.Unlock()
+			return
+		}
+		// The circuit is about to close, so past calls don't matter anymore
+		cb.clearOutcomes()
+	}
+	cb.mutex.Unlock()
+
+	cb.resetState()
 }
 
 func (cb *CircuitBreaker) resetState() {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
 	cb.FailureCount = 0
+	cb.SuccessCount = 0
+	cb.failedProbes = 0
+	cb.timeoutCount = 0
+	cb.trimmedFailures = 0
 	cb.FailureRecord = []string{}
+	cb.FailureTimes = []time.Time{}
 	cb.LastFailureTime = time.Time{}
 }
 
-func (cb *CircuitBreaker) recordFailure(err error) {
-	cb.FailureCount = cb.FailureCount + 1
-	cb.LastFailureTime = time.Now()
+func (cb *CircuitBreaker) recordFailure(state CircuitState, err error) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	cb.metrics.TotalFailures++
+	if state != IsHalfOpen && cb.debounced() {
+		// Too soon after the previous one to tell anything new, though a
+		// missed chance always tells it is still down
+		return
+	}
+
+	if state == IsHalfOpen {
+		cb.failedProbes = cb.failedProbes + 1
+	}
+
+	cb.pruneFailures()
+	cb.recordOutcome(false, false)
 	if err == nil {
 		err = fmt.Errorf("Service is relying on fallback")
 	}
-	cb.FailureRecord = append(cb.FailureRecord, err.Error())
+	if cb.FailureCount == 0 || cb.streakStart.IsZero() {
+		// Here it starts failing, as far as TripAfter is concerned
+		cb.streakStart = cb.clock.Now()
+	}
+	weight := cb.failureWeight(err)
+	cb.FailureCount = cb.FailureCount + weight
+	cb.SuccessCount = 0
+	cb.LastFailureTime = cb.clock.Now()
+	cb.shuffleRetryJitter()
+	// A heavy fail is recorded as many fails at once, so that the record
+	// still adds up when trimmed or pruned
+	for i := 0; i < weight; i++ {
+		cb.FailureRecord = append(cb.FailureRecord, err.Error())
+		cb.FailureTimes = append(cb.FailureTimes, cb.LastFailureTime)
+	}
+	if excess := len(cb.FailureRecord) - cb.Settings.MaxFailureRecords; excess > 0 {
+		// Only the most recent ones are worth keeping
+		cb.FailureRecord = cb.FailureRecord[excess:]
+		cb.FailureTimes = cb.FailureTimes[excess:]
+		cb.trimmedFailures = cb.trimmedFailures + excess
+	}
+	if state == IsHalfOpen {
+		// A missed chance is enough to keep it open, however many fails it
+		// takes by now, and there is no need for the count to grow on every
+		// single one of them
+		threshold := cb.failureThreshold()
+		if excess := cb.FailureCount - threshold; excess > 0 {
+			cb.forgetFailures(excess)
+		}
+		cb.FailureCount = max(cb.FailureCount, threshold)
+	}
+}
+
+// failingTooLong must be called with the lock held
+func (cb *CircuitBreaker) failingTooLong() bool {
+	if cb.Settings.TripAfter == 0 || cb.FailureCount == 0 || cb.streakStart.IsZero() {
+		return false
+	}
+	// From the first fail to the latest one, so it takes fails all along
+	return cb.LastFailureTime.Sub(cb.streakStart) >= cb.Settings.TripAfter
 }
 
-func (cb *CircuitBreaker) notifyState(preState, newState CircuitState) {
+// failureThreshold must be called with the lock held
+func (cb *CircuitBreaker) failureThreshold() int {
+	if cb.Settings.ThresholdFunc == nil {
+		return cb.Settings.FailureThreshold
+	}
+	// The busier it is, the more fails it may take
+	return max(cb.Settings.ThresholdFunc(len(cb.outcomes)), 1)
+}
+
+// failureWeight must be called with the lock held
+func (cb *CircuitBreaker) failureWeight(err error) int {
+	if cb.Settings.FailureWeight == nil {
+		return 1
+	}
+	// Whatever is not worth a fail at all is up to IsFailure to tell
+	return max(cb.Settings.FailureWeight(err), 1)
+}
+
+// forgetFailures must be called with the lock held
+func (cb *CircuitBreaker) forgetFailures(n int) {
+	cb.FailureCount = cb.FailureCount - n
+	// Whatever was trimmed off the record is the oldest, so it goes first
+	trimmed := min(n, cb.trimmedFailures)
+	cb.trimmedFailures = cb.trimmedFailures - trimmed
+	recorded := min(n-trimmed, len(cb.FailureRecord))
+	cb.FailureRecord = cb.FailureRecord[recorded:]
+	cb.FailureTimes = cb.FailureTimes[recorded:]
+	// Timeouts are some of the fails, so they can't outnumber them
+	cb.timeoutCount = min(cb.timeoutCount, cb.FailureCount)
+}
+
+// debounced must be called with the lock held
+func (cb *CircuitBreaker) debounced() bool {
+	if cb.Settings.FailureDebounce == 0 || cb.FailureCount == 0 {
+		return false
+	}
+	return cb.clock.Now().Sub(cb.LastFailureTime) < cb.Settings.FailureDebounce
+}
+
+// recordOutcome must be called with the lock held
+func (cb *CircuitBreaker) recordOutcome(success bool, slow bool) {
+	size := cb.Settings.RollingWindowSize
+	if size == 0 {
+		return
+	}
+	if len(cb.outcomes) < size {
+		cb.outcomes = append(cb.outcomes, success)
+		cb.slowOutcomes = append(cb.slowOutcomes, slow)
+		return
+	}
+	// Once the ring is full, the oldest outcome makes room for the newest one
+	cb.outcomes[cb.outcomeIndex] = success
+	cb.slowOutcomes[cb.outcomeIndex] = slow
+	cb.outcomeIndex = (cb.outcomeIndex + 1) % size
+}
+
+// clearOutcomes must be called with the lock held
+func (cb *CircuitBreaker) clearOutcomes() {
+	cb.outcomes = nil
+	cb.slowOutcomes = nil
+	cb.outcomeIndex = 0
+}
+
+func (cb *CircuitBreaker) countCall() {
+	cb.forgetIfIdle()
+
+	cb.mutex.Lock()
+	cb.metrics.TotalCalls++
+	cb.lastCallTime = cb.clock.Now()
+	hook := cb.Settings.MetricsHook
+	cb.mutex.Unlock()
+
+	if hook != nil {
+		hook.CallStarted()
+	}
+}
+
+// forgetIfIdle clears fails short of tripping once there were no calls for
+// long enough, so they don't add up to a trip days apart
+func (cb *CircuitBreaker) forgetIfIdle() {
+	cb.mutex.RLock()
+	idle := cb.idle()
+	cb.mutex.RUnlock()
+	if !idle {
+		return
+	}
+
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	// Somebody may have called in the meantime
+	if !cb.idle() {
+		return
+	}
+	cb.FailureCount = 0
+	cb.SuccessCount = 0
+	cb.timeoutCount = 0
+	cb.trimmedFailures = 0
+	cb.FailureRecord = []string{}
+	cb.FailureTimes = []time.Time{}
+	cb.clearOutcomes()
+}
+
+// idle must be called with the lock held
+func (cb *CircuitBreaker) idle() bool {
+	if cb.Settings.IdleResetTimeout == 0 || cb.lastCallTime.IsZero() {
+		return false
+	}
+	if cb.FailureCount == 0 && len(cb.outcomes) == 0 {
+		// Nothing to forget about
+		return false
+	}
+	if cb.forcedOpen || cb.tripped() {
+		// Once open it is up to RetryTimePeriod to give it a chance
+		return false
+	}
+	return cb.clock.Now().Sub(cb.lastCallTime) >= cb.Settings.IdleResetTimeout
+}
+
+// staleFailures must be called with the lock held
+func (cb *CircuitBreaker) staleFailures() int {
+	if cb.Settings.WindowDuration == 0 {
+		return 0
+	}
+	// Failures are recorded in order, so the stale ones come first
+	windowStart := cb.clock.Now().Add(-cb.Settings.WindowDuration)
+	stale := 0
+	for stale < len(cb.FailureTimes) && cb.FailureTimes[stale].Before(windowStart) {
+		stale++
+	}
+	if stale > 0 {
+		// Whatever was trimmed off the record is even older
+		stale = stale + cb.trimmedFailures
+	}
+	return stale
+}
+
+// pruneFailures must be called with the lock held
+func (cb *CircuitBreaker) pruneFailures() {
+	stale := cb.staleFailures()
+	if stale == 0 {
+		return
+	}
+	// Whatever happened before the window doesn't count anymore
+	cb.FailureCount = cb.FailureCount - stale
+	recorded := stale - cb.trimmedFailures
+	cb.FailureRecord = cb.FailureRecord[recorded:]
+	cb.FailureTimes = cb.FailureTimes[recorded:]
+	cb.trimmedFailures = 0
+}
+
+// notifySuccess must be called with the event lock held
+func (cb *CircuitBreaker) notifySuccess(latency time.Duration) {
+	if cb.Settings.OnSuccess != nil {
+		cb.Settings.OnSuccess(latency)
+	}
+	if cb.Settings.MetricsHook != nil {
+		cb.Settings.MetricsHook.CallSucceeded(latency)
+	}
+}
+
+// notifyFailure must be called with the event lock held
+func (cb *CircuitBreaker) notifyFailure(err error) {
+	// What went wrong with the service, rather than how we dealt with it
+	var callingErr *CallingError
+	if errors.As(err, &callingErr) {
+		err = callingErr.Cause
+	}
+	cb.logFailure(err)
+	if cb.Settings.OnFailure != nil {
+		cb.Settings.OnFailure(err)
+	}
+	if cb.Settings.MetricsHook != nil {
+		cb.Settings.MetricsHook.CallFailed(err)
+	}
+}
+
+// notifyState must be called with the event lock held
+func (cb *CircuitBreaker) notifyState(newState CircuitState) {
+	preState := cb.lastState
+	cb.lastState = newState
+
 	// Anytime state changes
 	if newState != preState {
-		// We notify it generally
-		if cb.Settings.OnStateChange != nil {
-			cb.Settings.OnStateChange()
-		}
-		// And specifically
-		switch newState {
-		case IsOpen:
-			if cb.Settings.OnTrip != nil {
-				cb.Settings.OnTrip()
-			}
-		case IsClosed:
-			if cb.Settings.OnReset != nil {
-				cb.Settings.OnReset()
-			}
+		// We keep track of it
+		transition := cb.recordTransition(preState, newState)
+		cb.publish(transition)
+		cb.logTransition(preState, newState)
+		if cb.Settings.StateStore != nil {
+			cb.saveState()
 		}
+		if cb.Settings.MetricsHook != nil {
+			// Metrics are not to be held back like callbacks are
+			cb.Settings.MetricsHook.StateChanged(preState, newState)
+		}
+		cb.notifyCallbacks(preState, newState)
+	}
+}
+
+// notifyCallbacks must be called with the event lock held
+func (cb *CircuitBreaker) notifyCallbacks(from, to CircuitState) {
+	if interval := cb.Settings.CallbackMinInterval; interval > 0 {
+		if cb.callbackTimer != nil {
+			// Whichever state it ends up at gets told once the timer fires
+			return
+		}
+		// It goes by the real clock, just like the timer does
+		if wait := interval - time.Since(cb.lastCallbackTime); wait > 0 {
+			cb.pendingFrom = from
+			cb.callbackTimer = time.AfterFunc(wait, cb.flushCallbacks)
+			return
+		}
+		cb.lastCallbackTime = time.Now()
+	}
+
+	// We notify it generally
+	if cb.Settings.OnStateChange != nil {
+		cb.Settings.OnStateChange()
+	}
+	if cb.Settings.OnTransition != nil {
+		cb.Settings.OnTransition(from, to)
+	}
+	// And specifically
+	switch to {
+	case IsOpen:
+		if cb.Settings.OnTrip != nil {
+			cb.Settings.OnTrip()
+		}
+	case IsHalfOpen:
+		if cb.Settings.OnHalfOpen != nil {
+			cb.Settings.OnHalfOpen()
+		}
+	case IsClosed:
+		if cb.Settings.OnReset != nil {
+			cb.Settings.OnReset()
+		}
+	}
+}
+
+func (cb *CircuitBreaker) flushCallbacks() {
+	cb.eventMutex.Lock()
+	defer cb.eventMutex.Unlock()
+
+	cb.callbackTimer = nil
+	if cb.isShutdown() {
+		// Nobody is supposed to hear from it anymore
+		return
+	}
+	if cb.pendingFrom == cb.lastState {
+		// It went back and forth, so there is nothing new to tell
+		return
 	}
+	cb.notifyCallbacks(cb.pendingFrom, cb.lastState)
 }
@@ -1,107 +1,2990 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math/rand"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
+func TestParseCircuitState(t *testing.T) {
+	for str, expected := range map[string]CircuitState{
+		"closed":    IsClosed,
+		"half-open": IsHalfOpen,
+		"open":      IsOpen,
+		"Closed":    IsClosed,
+		"HALF-OPEN": IsHalfOpen,
+		"oPeN":      IsOpen,
+	} {
+		state, err := ParseCircuitState(str)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, state)
+	}
+}
+
+func TestParseCircuitStateWithInvalidState(t *testing.T) {
+	state, err := ParseCircuitState("ajar")
+	assert.NotNil(t, err)
+	assert.Equal(t, "invalid", state.ToString())
+}
+
+func TestCircuitStateJSONRoundTrip(t *testing.T) {
+	for _, state := range []CircuitState{IsClosed, IsHalfOpen, IsOpen} {
+		data, err := json.Marshal(state)
+		assert.Nil(t, err)
+		assert.Equal(t, `"`+state.ToString()+`"`, string(data))
+
+		var parsed CircuitState
+		assert.Nil(t, json.Unmarshal(data, &parsed))
+		assert.Equal(t, state, parsed)
+	}
+}
+
+func TestCircuitStateJSONWithInvalidState(t *testing.T) {
+	var state CircuitState
+	assert.NotNil(t, json.Unmarshal([]byte(`"ajar"`), &state))
+	assert.NotNil(t, json.Unmarshal([]byte(`"invalid"`), &state))
+	assert.NotNil(t, json.Unmarshal([]byte(`3`), &state))
+	assert.Equal(t, CircuitState(0), state)
+}
+
+func TestDefaultSettings(t *testing.T) {
+	cb, err := NewCircuitBreaker(CircuitSettings{Service: healthService})
+	assert.Nil(t, err)
+	assert.Equal(t, 2*time.Second, cb.Settings.Timeout)
+	assert.Equal(t, 3*time.Second, cb.Settings.RetryTimePeriod)
+	assert.Equal(t, 1, cb.Settings.SuccessThreshold)
+	assert.Equal(t, 2*time.Second, DefautTimeout)
+	assert.Equal(t, 3*time.Second, DefaultRetryTimePeriod)
+}
+
 func TestErrorOnCreationWithoutProvideAService(t *testing.T) {
 	cb, err := createCircuitBreakerWithNoService()
 	assert.NotNil(t, err)
 	assert.Nil(t, cb)
 }
 
-func TestNoErrorOnCreationWithoutProvideAFallback(t *testing.T) {
-	cb, err := createCircuitBreakerWithNoFallback(healthService)
-	assert.Nil(t, err)
-	assert.NotNil(t, cb)
+func TestErrorOnCreationWithNegativeTimeout(t *testing.T) {
+	cb, err := NewCircuitBreaker(CircuitSettings{Service: healthService, Timeout: -time.Second})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "Timeout must not be negative")
+	assert.Nil(t, cb)
+}
+
+func TestErrorOnCreationWithNegativeRetryTimePeriod(t *testing.T) {
+	cb, err := NewCircuitBreaker(CircuitSettings{Service: healthService, RetryTimePeriod: -time.Second})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "RetryTimePeriod must not be negative")
+	assert.Nil(t, cb)
+}
+
+func TestErrorOnCreationWithNegativeFailureThreshold(t *testing.T) {
+	cb, err := NewCircuitBreaker(CircuitSettings{Service: healthService, FailureThreshold: -1})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "FailureThreshold must be at least 1")
+	assert.Nil(t, cb)
+}
+
+func TestErrorOnCreationWithNegativeWindowDuration(t *testing.T) {
+	cb, err := NewCircuitBreaker(CircuitSettings{Service: healthService, WindowDuration: -time.Second})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "WindowDuration must not be negative")
+	assert.Nil(t, cb)
+}
+
+func TestErrorOnCreationWithNegativeRollingWindowSize(t *testing.T) {
+	cb, err := NewCircuitBreaker(CircuitSettings{Service: healthService, ErrorPercentThreshold: 50, RollingWindowSize: -1})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "RollingWindowSize must not be negative")
+	assert.Nil(t, cb)
+}
+
+func TestErrorOnCreationWithNegativeMinRequestVolume(t *testing.T) {
+	cb, err := NewCircuitBreaker(CircuitSettings{Service: healthService, ErrorPercentThreshold: 50, RollingWindowSize: 10, MinRequestVolume: -1})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "MinRequestVolume must not be negative")
+	assert.Nil(t, cb)
+}
+
+func TestErrorOnCreationWithNegativeMaxFailureRecords(t *testing.T) {
+	cb, err := NewCircuitBreaker(CircuitSettings{Service: healthService, MaxFailureRecords: -1})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "MaxFailureRecords must not be negative")
+	assert.Nil(t, cb)
+}
+
+func TestErrorOnCreationWithNegativeMaxHistory(t *testing.T) {
+	cb, err := NewCircuitBreaker(CircuitSettings{Service: healthService, MaxHistory: -1})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "MaxHistory must not be negative")
+	assert.Nil(t, cb)
+}
+
+func TestNoErrorOnCreationWithoutProvideAFallback(t *testing.T) {
+	cb, err := createCircuitBreakerWithNoFallback(healthService)
+	assert.Nil(t, err)
+	assert.NotNil(t, cb)
+}
+
+func TestServiceIsHealth(t *testing.T) {
+	cb, _ := createCircuitBreaker(healthService, fallback)
+	res, fallbacked, err := cb.Call()
+	assert.Nil(t, err)
+	assert.Equal(t, false, fallbacked)
+	assert.Equal(t, healthServiceContent, res)
+	assert.Equal(t, IsClosed, cb.State())
+}
+
+func TestServiceIsSlow(t *testing.T) {
+	cb, _ := createCircuitBreaker(slowService, fallback)
+	res, fallbacked, err := cb.Call()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), fallbackDueToErrorMessage)
+	assert.True(t, fallbacked)
+	assert.Equal(t, fallbackContent, res)
+	assert.Equal(t, IsClosed, cb.State())
+}
+
+func TestServiceIsSlowButThereIsNoFallback(t *testing.T) {
+	cb, _ := createCircuitBreakerWithNoFallback(slowService)
+	res, fallbacked, err := cb.Call()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), serviceTimedOutMessage)
+	assert.Equal(t, false, fallbacked)
+	assert.Nil(t, res)
+	assert.Equal(t, IsClosed, cb.State())
+}
+
+func TestCircuitShouldOpenWithNoFallback(t *testing.T) {
+	cb, _ := createCircuitBreakerWithNoFallback(failingService)
+
+	for i := 0; i < cb.Settings.FailureThreshold; i++ {
+		_, fallbacked, err := cb.Call()
+		assert.False(t, fallbacked)
+		assert.True(t, errors.Is(err, failingServiceError))
+		assert.True(t, errors.Is(err, ErrNoFallback))
+	}
+	assert.Equal(t, IsOpen, cb.State())
+	assert.Equal(t, cb.Settings.FailureThreshold, cb.CurrentFailureCount())
+}
+
+func TestCallWithTimeoutOverridesTimeout(t *testing.T) {
+	cb, _ := createCircuitBreaker(createSleepyService(200*time.Millisecond), fallback)
+
+	// too short for the service
+	res, fallbacked, err := cb.CallWithTimeout(100 * time.Millisecond)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "Service timed out after 100 milliseconds")
+	assert.True(t, fallbacked)
+	assert.Equal(t, fallbackContent, res)
+	assert.Equal(t, DefautTimeout, cb.Settings.Timeout)
+
+	// long enough for the service
+	res, fallbacked, err = cb.CallWithTimeout(500 * time.Millisecond)
+	assert.Nil(t, err)
+	assert.False(t, fallbacked)
+	assert.Equal(t, healthServiceContent, res)
+}
+
+func TestCallWithZeroTimeoutUsesTimeout(t *testing.T) {
+	cb, _ := createCircuitBreaker(createSleepyService(200*time.Millisecond), fallback)
+	cb.Settings.Timeout = 100 * time.Millisecond
+
+	_, fallbacked, err := cb.CallWithTimeout(0)
+	assert.Contains(t, err.Error(), "Service timed out after 100 milliseconds")
+	assert.True(t, fallbacked)
+}
+
+func TestStaticFallbackWhileOpen(t *testing.T) {
+	cb, _ := createCircuitBreaker(healthService, StaticFallback("x"))
+	cb.Trip()
+
+	res, fallbacked, err := cb.Call()
+	assert.Equal(t, "x", res)
+	assert.True(t, fallbacked)
+	assert.True(t, errors.Is(err, ErrCircuitOpen))
+}
+
+func TestFallbackWithCauseIsPreferred(t *testing.T) {
+	cb, _ := createCircuitBreaker(failingService, fallback)
+	cb.Settings.FallbackWithCause = func(cause error) (interface{}, error) {
+		return cause.Error(), nil
+	}
+
+	res, fallbacked, err := cb.Call()
+	assert.NotNil(t, err)
+	assert.True(t, fallbacked)
+	assert.Contains(t, res, failingServiceError.Error())
+}
+
+func TestFallbackWithCauseKnowsAboutTimeout(t *testing.T) {
+	var causes []error
+	cb, _ := createCircuitBreakerWithNoFallback(slowService)
+	cb.Settings.FallbackWithCause = func(cause error) (interface{}, error) {
+		causes = append(causes, cause)
+		return fallbackContent, nil
+	}
+
+	res, fallbacked, _ := cb.Call()
+	assert.True(t, fallbacked)
+	assert.Equal(t, fallbackContent, res)
+	assert.Equal(t, 1, len(causes))
+	assert.IsType(t, &CallingError{}, causes[0])
+	assert.Contains(t, causes[0].Error(), serviceTimedOutMessage)
+}
+
+func TestFallbackWithCauseKnowsAboutOpenState(t *testing.T) {
+	var causes []error
+	cb, _ := createCircuitBreakerWithNoFallback(failingService)
+	cb.Settings.FallbackWithCause = func(cause error) (interface{}, error) {
+		causes = append(causes, cause)
+		return fallbackContent, nil
+	}
+
+	for i := 0; i < cb.Settings.FailureThreshold; i++ {
+		cb.Call()
+	}
+	assert.Equal(t, IsOpen, cb.State())
+
+	res, fallbacked, err := cb.Call()
+	assert.Contains(t, err.Error(), fallbackDueToOpenStateMessage)
+	assert.True(t, fallbacked)
+	assert.Equal(t, fallbackContent, res)
+	assert.Equal(t, cb.Settings.FailureThreshold+1, len(causes))
+	assert.Contains(t, causes[len(causes)-1].Error(), circuitIsOpenMessage)
+}
+
+func TestErrorsNotClassifiedAsFailureAreReturnedAsIs(t *testing.T) {
+	cb, _ := createCircuitBreaker(notFoundService, fallback)
+	cb.Settings.IsFailure = ignoreNotFound
+
+	for i := 0; i < 2*cb.Settings.FailureThreshold; i++ {
+		res, fallbacked, err := cb.Call()
+		assert.Equal(t, errNotFound, err)
+		assert.False(t, fallbacked)
+		assert.Nil(t, res)
+	}
+	assert.Equal(t, 0, cb.CurrentFailureCount())
+	assert.Equal(t, IsClosed, cb.State())
+}
+
+func TestErrorsClassifiedAsFailureTripTheCircuit(t *testing.T) {
+	cb, _ := createCircuitBreaker(failingService, fallback)
+	cb.Settings.IsFailure = ignoreNotFound
+
+	for i := 0; i < cb.Settings.FailureThreshold; i++ {
+		res, fallbacked, err := cb.Call()
+		assert.Contains(t, err.Error(), fallbackDueToErrorMessage)
+		assert.True(t, fallbacked)
+		assert.Equal(t, fallbackContent, res)
+	}
+	assert.Equal(t, IsOpen, cb.State())
+}
+
+func TestServicePanicIsTakenAsFailure(t *testing.T) {
+	cb, _ := createCircuitBreaker(panickingService, fallback)
+
+	for i := 0; i < cb.Settings.FailureThreshold; i++ {
+		// closed before each call, as it is the last one that trips it
+		assert.Equal(t, IsClosed, cb.State())
+		res, fallbacked, err := cb.Call()
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), fallbackDueToErrorMessage)
+		assert.Contains(t, err.Error(), servicePanickedMessage)
+		assert.True(t, fallbacked)
+		assert.Equal(t, fallbackContent, res)
+	}
+	assert.Equal(t, IsOpen, cb.State())
+	assert.Contains(t, cb.FailureRecord[0], servicePanickedMessage)
+}
+
+func TestFallbackPanicIsReturnedAsError(t *testing.T) {
+	cb, _ := createCircuitBreaker(slowService, panickingFallback)
+
+	res, fallbacked, err := cb.Call()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), fallbackPanickedMessage)
+	assert.Contains(t, err.Error(), serviceTimedOutMessage)
+	assert.True(t, fallbacked)
+	assert.Nil(t, res)
+	assert.Equal(t, 1, cb.CurrentFailureCount())
+}
+
+var cacheMissError = errors.New("Cache miss")
+
+func cacheMissFallback() (interface{}, error) {
+	return nil, cacheMissError
+}
+
+func TestFallbacksAreChained(t *testing.T) {
+	cb, _ := createCircuitBreaker(failingService, cacheMissFallback)
+	cb.Settings.Fallbacks = []Callable{fallback}
+
+	res, fallbacked, err := cb.Call()
+	assert.Equal(t, fallbackContent, res)
+	assert.True(t, fallbacked)
+	assert.True(t, errors.Is(err, failingServiceError))
+	assert.False(t, errors.Is(err, cacheMissError))
+	assert.Equal(t, int64(2), cb.Metrics().TotalFallbacks)
+}
+
+func TestFallbacksStopAtFirstSuccess(t *testing.T) {
+	hits := 0
+	cb, _ := createCircuitBreakerWithNoFallback(failingService)
+	cb.Settings.Fallbacks = []Callable{fallback, func() (interface{}, error) {
+		hits++
+		return nil, nil
+	}}
+
+	res, fallbacked, _ := cb.Call()
+	assert.Equal(t, fallbackContent, res)
+	assert.True(t, fallbacked)
+	assert.Equal(t, 0, hits)
+}
+
+func TestFallbacksAllFailing(t *testing.T) {
+	cb, _ := createCircuitBreaker(failingService, cacheMissFallback)
+	cb.Settings.Fallbacks = []Callable{panickingFallback}
+
+	res, fallbacked, err := cb.Call()
+	assert.Nil(t, res)
+	assert.True(t, fallbacked)
+	assert.True(t, errors.Is(err, cacheMissError))
+	assert.True(t, errors.Is(err, failingServiceError))
+	assert.Contains(t, err.Error(), "Fallback 1 of 2 failed: "+cacheMissError.Error())
+	assert.Contains(t, err.Error(), "Fallback 2 of 2 failed: "+fallbackPanickedMessage)
+}
+
+func TestExecuteSharesCircuitAmongOperations(t *testing.T) {
+	cb, _ := createCircuitBreaker(healthService, fallback)
+	getUser := func() (interface{}, error) {
+		return nil, failingServiceError
+	}
+	getOrder := func() (interface{}, error) {
+		return nil, errNotFound
+	}
+
+	_, fallbacked, err := cb.Execute(getUser)
+	assert.True(t, fallbacked)
+	assert.True(t, errors.Is(err, failingServiceError))
+	assert.Equal(t, 1, cb.CurrentFailureCount())
+
+	_, fallbacked, err = cb.Execute(getOrder)
+	assert.True(t, fallbacked)
+	assert.True(t, errors.Is(err, errNotFound))
+	assert.Equal(t, 2, cb.CurrentFailureCount())
+	assert.Equal(t, IsOpen, cb.State())
+
+	// the configured service is just as cut off as any other operation
+	res, fallbacked, err := cb.Call()
+	assert.Contains(t, err.Error(), fallbackDueToOpenStateMessage)
+	assert.True(t, fallbacked)
+	assert.Equal(t, fallbackContent, res)
+}
+
+func TestExecuteIgnoresConfiguredService(t *testing.T) {
+	cb, _ := createCircuitBreaker(failingService, fallback)
+
+	res, fallbacked, err := cb.Execute(healthService)
+	assert.Nil(t, err)
+	assert.False(t, fallbacked)
+	assert.Equal(t, healthServiceContent, res)
+	assert.Equal(t, int64(1), cb.Metrics().TotalSuccesses)
+}
+
+func TestExecuteAllShortCircuitsOnceTripped(t *testing.T) {
+	cb, _ := createCircuitBreaker(healthService, fallback)
+	hits := 0
+	op := func() (interface{}, error) {
+		hits++
+		return nil, failingServiceError
+	}
+
+	ops := []Callable{op, op, op, op, op}
+	results := cb.ExecuteAll(ops)
+	assert.Equal(t, len(ops), len(results))
+	assert.Equal(t, cb.Settings.FailureThreshold, hits)
+
+	for i, result := range results {
+		assert.True(t, result.Fallbacked)
+		assert.Equal(t, fallbackContent, result.Content)
+		if i < cb.Settings.FailureThreshold {
+			assert.True(t, errors.Is(result.Err, failingServiceError))
+		} else {
+			assert.True(t, errors.Is(result.Err, ErrCircuitOpen))
+		}
+	}
+	assert.Equal(t, IsOpen, cb.State())
+}
+
+func TestExecuteAllKeepsOrder(t *testing.T) {
+	cb, _ := createCircuitBreaker(healthService, fallback)
+	ops := []Callable{}
+	for i := 0; i < 3; i++ {
+		content := i
+		ops = append(ops, func() (interface{}, error) {
+			return content, nil
+		})
+	}
+
+	results := cb.ExecuteAll(ops)
+	for i, result := range results {
+		assert.Equal(t, CallResult{Content: i}, result)
+	}
+}
+
+func TestProtectGoesThroughCircuit(t *testing.T) {
+	cb, _ := createCircuitBreaker(healthService, fallback)
+	protected := cb.Protect(failingService)
+
+	for i := 0; i < cb.Settings.FailureThreshold; i++ {
+		res, err := protected()
+		assert.Equal(t, fallbackContent, res)
+		assert.True(t, errors.Is(err, failingServiceError))
+	}
+	assert.Equal(t, IsOpen, cb.State())
+}
+
+func TestProtectNestsCircuits(t *testing.T) {
+	inner, _ := createCircuitBreakerWithNoFallback(failingService)
+	outer, _ := createCircuitBreaker(healthService, fallback)
+	protected := outer.Protect(inner.Protect(failingService))
+
+	for i := 0; i < inner.Settings.FailureThreshold; i++ {
+		res, err := protected()
+		assert.Equal(t, fallbackContent, res)
+		assert.True(t, errors.Is(err, failingServiceError))
+	}
+	assert.Equal(t, IsOpen, inner.State())
+	assert.Equal(t, IsOpen, outer.State())
+
+	// the outer one doesn't even get to the inner one anymore
+	res, err := protected()
+	assert.Equal(t, fallbackContent, res)
+	assert.True(t, errors.Is(err, ErrCircuitOpen))
+	assert.Equal(t, int64(inner.Settings.FailureThreshold), inner.Metrics().TotalCalls)
+}
+
+func TestProtectNestsFallbacksAsSuccess(t *testing.T) {
+	inner, _ := createCircuitBreaker(failingService, StaticFallback("cached"))
+	inner.Settings.TreatFallbackAsSuccess = true
+	outer, _ := createCircuitBreaker(healthService, fallback)
+	protected := outer.Protect(inner.Protect(failingService))
+
+	for i := 0; i < inner.Settings.FailureThreshold+1; i++ {
+		res, err := protected()
+		assert.Equal(t, "cached", res)
+		assert.Nil(t, err)
+	}
+	// the inner one took care of it, so the outer one is fine
+	assert.Equal(t, IsOpen, inner.State())
+	assert.Equal(t, IsClosed, outer.State())
+}
+
+func TestFallbackComesWithErrorByDefault(t *testing.T) {
+	cb, _ := createCircuitBreaker(failingService, fallback)
+
+	res, fallbacked, err := cb.Call()
+	assert.True(t, fallbacked)
+	assert.Equal(t, fallbackContent, res)
+	assert.Contains(t, err.Error(), fallbackDueToErrorMessage)
+}
+
+func TestFallbackComesWithNoErrorWhenTreatedAsSuccess(t *testing.T) {
+	cb, _ := createCircuitBreaker(failingService, fallback)
+	cb.Settings.TreatFallbackAsSuccess = true
+
+	res, fallbacked, err := cb.Call()
+	assert.Nil(t, err)
+	assert.True(t, fallbacked)
+	assert.Equal(t, fallbackContent, res)
+	// it is a fail as far as the circuit is concerned though
+	assert.Equal(t, 1, cb.CurrentFailureCount())
+	assert.Contains(t, cb.FailureRecord[0], failingServiceError.Error())
+
+	cb.Call()
+	assert.Equal(t, IsOpen, cb.State())
+
+	res, fallbacked, err = cb.Call()
+	assert.Nil(t, err)
+	assert.True(t, fallbacked)
+	assert.Equal(t, fallbackContent, res)
+}
+
+func TestHalfOpenNeedsServiceSuccessWhenFallbackTreatedAsSuccess(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := createCircuitBreakerWithClock(failingService, fallback, clock)
+	cb.Settings.TreatFallbackAsSuccess = true
+	cb.Trip()
+	clock.Advance(cb.Settings.RetryTimePeriod + time.Millisecond)
+	assert.Equal(t, IsHalfOpen, cb.State())
+
+	res, fallbacked, err := cb.Call()
+	assert.Nil(t, err)
+	assert.True(t, fallbacked)
+	assert.Equal(t, fallbackContent, res)
+	// the service missed its chance, fallback or not
+	assert.Equal(t, IsOpen, cb.State())
+
+	clock.Advance(cb.Settings.RetryTimePeriod + time.Millisecond)
+	cb.Settings.Service = healthService
+	_, fallbacked, _ = cb.Call()
+	assert.False(t, fallbacked)
+	assert.Equal(t, IsClosed, cb.State())
+}
+
+func TestFailedFallbackComesWithErrorWhenTreatedAsSuccess(t *testing.T) {
+	cb, _ := createCircuitBreaker(failingService, panickingFallback)
+	cb.Settings.TreatFallbackAsSuccess = true
+
+	_, fallbacked, err := cb.Call()
+	assert.True(t, fallbacked)
+	assert.Contains(t, err.Error(), fallbackPanickedMessage)
+
+	cb.Settings.Fallback = nil
+	_, fallbacked, err = cb.Call()
+	assert.False(t, fallbacked)
+	assert.True(t, errors.Is(err, ErrNoFallback))
+}
+
+func TestFailFastWhenOpen(t *testing.T) {
+	fallbackCalls := 0
+	cb, _ := createCircuitBreaker(failingService, func() (interface{}, error) {
+		fallbackCalls++
+		return fallbackContent, nil
+	})
+	cb.Settings.FailFast = true
+
+	for i := 0; i < cb.Settings.FailureThreshold; i++ {
+		cb.Call()
+	}
+	assert.Equal(t, IsOpen, cb.State())
+	assert.Equal(t, cb.Settings.FailureThreshold, fallbackCalls)
+
+	res, fallbacked, err := cb.Call()
+	assert.Nil(t, res)
+	assert.False(t, fallbacked)
+	assert.True(t, errors.Is(err, ErrCircuitOpen))
+	assert.Equal(t, cb.Settings.FailureThreshold, fallbackCalls)
+	assert.Equal(t, int64(cb.Settings.FailureThreshold), cb.Metrics().TotalFallbacks)
+}
+
+func TestFailFastWhenOpenWithNoFallback(t *testing.T) {
+	cb, _ := createCircuitBreakerWithNoFallback(healthService)
+	cb.Settings.FailFast = true
+	cb.Trip()
+
+	res, fallbacked, err := cb.Call()
+	assert.Nil(t, res)
+	assert.False(t, fallbacked)
+	assert.True(t, errors.Is(err, ErrCircuitOpen))
+}
+
+func TestShadowModeAlwaysCallsService(t *testing.T) {
+	clock := newFakeClock()
+	hits := 0
+	cb, _ := createCircuitBreakerWithClock(func() (interface{}, error) {
+		hits++
+		return nil, failingServiceError
+	}, fallback, clock)
+	cb.Settings.ShadowMode = true
+
+	for i := 0; i < cb.Settings.FailureThreshold+3; i++ {
+		res, fallbacked, err := cb.Call()
+		assert.Nil(t, res)
+		assert.False(t, fallbacked)
+		assert.True(t, errors.Is(err, failingServiceError))
+		assert.False(t, errors.Is(err, ErrCircuitOpen))
+	}
+	// it would have been open for a while now
+	assert.Equal(t, cb.Settings.FailureThreshold+3, hits)
+	assert.Equal(t, IsOpen, cb.State())
+	assert.Equal(t, cb.Settings.FailureThreshold, cb.CurrentFailureCount())
+	assert.Equal(t, int64(0), cb.Metrics().TotalFallbacks)
+
+	// and it still goes by the state it would be at
+	clock.Advance(cb.Settings.RetryTimePeriod + time.Millisecond)
+	assert.Equal(t, IsHalfOpen, cb.State())
+	cb.Settings.Service = healthService
+	res, fallbacked, err := cb.Call()
+	assert.Equal(t, healthServiceContent, res)
+	assert.False(t, fallbacked)
+	assert.Nil(t, err)
+	assert.Equal(t, IsClosed, cb.State())
+}
+
+func TestShadowModeTellsAboutWouldBeRejections(t *testing.T) {
+	hits := 0
+	cb, _ := createCircuitBreaker(func() (interface{}, error) {
+		hits++
+		return healthServiceContent, nil
+	}, fallback)
+	cb.Settings.ShadowMode = true
+	rejections := 0
+	cb.Settings.OnReject = func() {
+		rejections++
+	}
+	cb.Trip()
+
+	res, _, err := cb.Call()
+	assert.Equal(t, healthServiceContent, res)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, hits)
+	assert.Equal(t, 1, rejections)
+	// a real circuit would have learned nothing from it
+	assert.Equal(t, IsOpen, cb.State())
+}
+
+func TestOpenStateWithNoFallback(t *testing.T) {
+	cb, _ := createCircuitBreakerWithNoFallback(healthService)
+	cb.Trip()
+
+	res, fallbacked, err := cb.Call()
+	assert.Nil(t, res)
+	assert.False(t, fallbacked)
+	assert.True(t, errors.Is(err, ErrCircuitOpen))
+	assert.True(t, errors.Is(err, ErrNoFallback))
+	assert.NotContains(t, err.Error(), fallbackDueToOpenStateMessage)
+}
+
+func TestOpenStateWithFallback(t *testing.T) {
+	cb, _ := createCircuitBreaker(healthService, fallback)
+	cb.Trip()
+
+	res, fallbacked, err := cb.Call()
+	assert.Equal(t, fallbackContent, res)
+	assert.True(t, fallbacked)
+	assert.True(t, errors.Is(err, ErrCircuitOpen))
+	assert.False(t, errors.Is(err, ErrNoFallback))
+	assert.Contains(t, err.Error(), fallbackDueToOpenStateMessage)
+}
+
+func TestNamePrefixesErrors(t *testing.T) {
+	cb, _ := createCircuitBreaker(slowService, fallback)
+	cb.Settings.Name = "payments"
+	cb.Settings.Timeout = 100 * time.Millisecond
+
+	_, _, err := cb.Call()
+	assert.True(t, strings.HasPrefix(err.Error(), "[payments] "))
+	assert.Contains(t, err.Error(), "Service timed out after 100 milliseconds")
+	assert.True(t, errors.Is(err, ErrServiceTimeout))
+
+	cb.Trip()
+	_, _, err = cb.Call()
+	assert.Equal(t, "[payments] "+fallbackDueToOpenStateMessage+": "+circuitIsOpenMessage, err.Error())
+	assert.True(t, errors.Is(err, ErrCircuitOpen))
+}
+
+func TestNoNameNoPrefix(t *testing.T) {
+	cb, _ := createCircuitBreaker(healthService, fallback)
+	cb.Trip()
+
+	_, _, err := cb.Call()
+	assert.Equal(t, fallbackDueToOpenStateMessage+": "+circuitIsOpenMessage, err.Error())
+}
+
+func TestCircuitErrorWhileOpen(t *testing.T) {
+	cb, _ := createCircuitBreaker(failingService, fallback)
+	cb.Settings.Name = "payments"
+
+	for i := 0; i < cb.Settings.FailureThreshold; i++ {
+		cb.Call()
+	}
+	_, _, err := cb.Call()
+
+	var circuitErr *CircuitError
+	assert.True(t, errors.As(err, &circuitErr))
+	assert.Equal(t, "payments", circuitErr.Name)
+	assert.Equal(t, IsOpen, circuitErr.State)
+	assert.Equal(t, cb.Settings.FailureThreshold, circuitErr.FailureCount)
+	assert.True(t, errors.Is(circuitErr.Cause, ErrCircuitOpen))
+	assert.Equal(t, "[payments] "+circuitErr.Cause.Error(), err.Error())
+}
+
+func TestCircuitErrorOnFallback(t *testing.T) {
+	cb, _ := createCircuitBreaker(failingService, fallback)
+
+	_, fallbacked, err := cb.Call()
+	assert.True(t, fallbacked)
+
+	var circuitErr *CircuitError
+	assert.True(t, errors.As(err, &circuitErr))
+	assert.Equal(t, "", circuitErr.Name)
+	assert.Equal(t, IsClosed, circuitErr.State)
+	assert.Equal(t, 1, circuitErr.FailureCount)
+	assert.True(t, errors.Is(err, failingServiceError))
+	assert.Equal(t, circuitErr.Cause.Error(), err.Error())
+}
+
+func TestCircuitErrorWithNoFallback(t *testing.T) {
+	cb, _ := createCircuitBreakerWithNoFallback(failingService)
+
+	_, _, err := cb.Call()
+
+	var circuitErr *CircuitError
+	assert.True(t, errors.As(err, &circuitErr))
+	assert.True(t, errors.Is(err, ErrNoFallback))
+	assert.Equal(t, 1, circuitErr.FailureCount)
+}
+
+func TestNoCircuitErrorForRegularErrors(t *testing.T) {
+	cb, _ := createCircuitBreaker(notFoundService, fallback)
+	cb.Settings.IsFailure = ignoreNotFound
+
+	_, _, err := cb.Call()
+
+	var circuitErr *CircuitError
+	assert.False(t, errors.As(err, &circuitErr))
+	assert.Equal(t, errNotFound, err)
+}
+
+func TestErrorsTellCircuitOpen(t *testing.T) {
+	cb, _ := createCircuitBreaker(healthService, fallback)
+	cb.Trip()
+
+	_, fallbacked, err := cb.Call()
+	assert.True(t, fallbacked)
+	assert.True(t, errors.Is(err, ErrCircuitOpen))
+	assert.False(t, errors.Is(err, ErrNoFallback))
+
+	cb.Settings.Fallback = panickingFallback
+	_, fallbacked, err = cb.Call()
+	assert.True(t, fallbacked)
+	assert.True(t, errors.Is(err, ErrCircuitOpen))
+	assert.Contains(t, err.Error(), fallbackPanickedMessage)
+}
+
+func TestInitialStateOpen(t *testing.T) {
+	clock := newFakeClock()
+	tripCount := 0
+	cb, err := NewCircuitBreaker(CircuitSettings{
+		Service:      healthService,
+		Fallback:     fallback,
+		Clock:        clock,
+		InitialState: IsOpen,
+		OnTrip: func() {
+			tripCount++
+		},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, IsOpen, cb.State())
+
+	res, fallbacked, err := cb.Call()
+	assert.True(t, errors.Is(err, ErrCircuitOpen))
+	assert.True(t, fallbacked)
+	assert.Equal(t, fallbackContent, res)
+	assert.Equal(t, 0, tripCount)
+
+	clock.Advance(cb.Settings.RetryTimePeriod + time.Millisecond)
+	assert.Equal(t, IsHalfOpen, cb.State())
+
+	res, fallbacked, err = cb.Call()
+	assert.Nil(t, err)
+	assert.False(t, fallbacked)
+	assert.Equal(t, healthServiceContent, res)
+	assert.Equal(t, IsClosed, cb.State())
+}
+
+func TestInitialStateIsClosedByDefault(t *testing.T) {
+	cb, _ := createCircuitBreaker(healthService, fallback)
+	assert.Equal(t, IsClosed, cb.State())
+
+	cb, err := NewCircuitBreaker(CircuitSettings{Service: healthService, InitialState: IsClosed})
+	assert.Nil(t, err)
+	assert.Equal(t, IsClosed, cb.State())
+}
+
+func TestInitialStateHalfOpenIsRejected(t *testing.T) {
+	cb, err := NewCircuitBreaker(CircuitSettings{Service: healthService, InitialState: IsHalfOpen})
+	assert.Nil(t, cb)
+	assert.NotNil(t, err)
+
+	cb, err = NewCircuitBreaker(CircuitSettings{Service: healthService, InitialState: CircuitState(42)})
+	assert.Nil(t, cb)
+	assert.NotNil(t, err)
+}
+
+func TestHealthyOnlyWhenClosed(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := createCircuitBreakerWithClock(failingService, fallback, clock)
+	assert.True(t, cb.Healthy())
+
+	for i := 0; i < cb.Settings.FailureThreshold; i++ {
+		cb.Call()
+	}
+	assert.False(t, cb.Healthy())
+
+	clock.Advance(cb.Settings.RetryTimePeriod + time.Millisecond)
+	assert.Equal(t, IsHalfOpen, cb.State())
+	assert.False(t, cb.Healthy())
+
+	cb.Settings.Service = healthService
+	cb.Call()
+	assert.True(t, cb.Healthy())
+}
+
+func TestTimeUntilHalfOpen(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := createCircuitBreakerWithClock(failingService, fallback, clock)
+	assert.Equal(t, time.Duration(0), cb.TimeUntilHalfOpen())
+
+	for i := 0; i < cb.Settings.FailureThreshold; i++ {
+		cb.Call()
+	}
+	assert.Equal(t, cb.Settings.RetryTimePeriod, cb.TimeUntilHalfOpen())
+
+	clock.Advance(time.Second)
+	assert.Equal(t, cb.Settings.RetryTimePeriod-time.Second, cb.TimeUntilHalfOpen())
+
+	clock.Advance(cb.Settings.RetryTimePeriod - time.Second)
+	assert.Equal(t, IsOpen, cb.State())
+	assert.Equal(t, time.Duration(0), cb.TimeUntilHalfOpen())
+
+	clock.Advance(time.Millisecond)
+	assert.Equal(t, IsHalfOpen, cb.State())
+	assert.Equal(t, time.Duration(0), cb.TimeUntilHalfOpen())
+}
+
+func TestTimeUntilHalfOpenWhenForcedOpen(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := createCircuitBreakerWithClock(healthService, fallback, clock)
+
+	cb.ForceOpen()
+	assert.Equal(t, time.Duration(0), cb.TimeUntilHalfOpen())
+}
+
+func TestTimeUntilHalfOpenWhenForcedOpenAfterFailure(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := createCircuitBreakerWithClock(failingService, fallback, clock)
+
+	cb.Call()
+	cb.ForceOpen()
+	// the failure would tell otherwise, but it never goes half-open
+	assert.Equal(t, time.Duration(0), cb.TimeUntilHalfOpen())
+	clock.Advance(cb.Settings.RetryTimePeriod + time.Millisecond)
+	assert.Equal(t, IsOpen, cb.State())
+}
+
+func TestNilResponseIsFailureByDefault(t *testing.T) {
+	cb, _ := createCircuitBreaker(nilService, fallback)
+
+	res, fallbacked, err := cb.Call()
+	assert.Contains(t, err.Error(), serviceRespondIsNilMessage)
+	assert.True(t, fallbacked)
+	assert.Equal(t, fallbackContent, res)
+	assert.Equal(t, 1, cb.CurrentFailureCount())
+}
+
+func TestNilResponseIsFineWhenAllowed(t *testing.T) {
+	cb, _ := createCircuitBreaker(nilService, fallback)
+	cb.Settings.AllowNilResponse = true
+
+	for i := 0; i < cb.Settings.FailureThreshold; i++ {
+		res, fallbacked, err := cb.Call()
+		assert.Nil(t, err)
+		assert.False(t, fallbacked)
+		assert.Nil(t, res)
+	}
+	assert.Equal(t, 0, cb.CurrentFailureCount())
+	assert.Equal(t, IsClosed, cb.State())
+}
+
+func TestIsSuccessCanTellContentIsFailure(t *testing.T) {
+	cb, _ := createCircuitBreaker(errorStatusService, fallback)
+	cb.Settings.IsSuccess = isStatusSuccess
+
+	for i := 0; i < cb.Settings.FailureThreshold; i++ {
+		res, fallbacked, err := cb.Call()
+		assert.Contains(t, err.Error(), serviceRespondIsNotSuccessMessage)
+		assert.True(t, fallbacked)
+		assert.Equal(t, fallbackContent, res)
+	}
+	assert.Equal(t, IsOpen, cb.State())
+}
+
+func TestIsSuccessCanTellErrorIsSuccess(t *testing.T) {
+	cb, _ := createCircuitBreaker(failingService, fallback)
+	cb.Settings.IsSuccess = func(content interface{}, err error) bool {
+		return true
+	}
+
+	for i := 0; i < cb.Settings.FailureThreshold; i++ {
+		res, fallbacked, err := cb.Call()
+		assert.Equal(t, failingServiceError, err)
+		assert.False(t, fallbacked)
+		assert.Nil(t, res)
+	}
+	assert.Equal(t, IsClosed, cb.State())
+	assert.Equal(t, 0, cb.CurrentFailureCount())
+}
+
+func TestIsSuccessKeepsTheErrorOnFailure(t *testing.T) {
+	cb, _ := createCircuitBreaker(failingService, fallback)
+	cb.Settings.IsSuccess = isStatusSuccess
+
+	_, fallbacked, err := cb.Call()
+	assert.True(t, fallbacked)
+	assert.True(t, errors.Is(err, failingServiceError))
+
+	cb.Settings.Service = func() (interface{}, error) {
+		return statusResponse{200}, nil
+	}
+	res, fallbacked, err := cb.Call()
+	assert.Nil(t, err)
+	assert.False(t, fallbacked)
+	assert.Equal(t, statusResponse{200}, res)
+}
+
+func TestTimeoutsAreCountedApartFromErrors(t *testing.T) {
+	cb, _ := createCircuitBreaker(failingService, fallback)
+	cb.Settings.FailureThreshold = 3
+	cb.Settings.Timeout = 100 * time.Millisecond
+
+	cb.Call()
+	assert.Equal(t, 1, cb.CurrentFailureCount())
+	assert.Equal(t, 0, cb.TimeoutCount())
+
+	cb.Settings.Service = slowService
+	cb.Call()
+	assert.Equal(t, 2, cb.CurrentFailureCount())
+	assert.Equal(t, 1, cb.TimeoutCount())
+
+	// both kinds count toward tripping
+	cb.Call()
+	assert.Equal(t, 2, cb.TimeoutCount())
+	assert.Equal(t, IsOpen, cb.State())
+
+	cb.Reset()
+	assert.Equal(t, 0, cb.TimeoutCount())
+}
+
+func TestTimeoutCountIsClearedOnSuccess(t *testing.T) {
+	cb, _ := createCircuitBreaker(slowService, fallback)
+	cb.Settings.Timeout = 100 * time.Millisecond
+
+	cb.Call()
+	assert.Equal(t, 1, cb.TimeoutCount())
+
+	cb.Settings.Service = healthService
+	cb.Call()
+	assert.Equal(t, 0, cb.TimeoutCount())
+}
+
+func TestErrorsTellServiceTimeout(t *testing.T) {
+	cb, _ := createCircuitBreakerWithNoFallback(slowService)
+	cb.Settings.Timeout = 100 * time.Millisecond
+
+	_, fallbacked, err := cb.Call()
+	assert.False(t, fallbacked)
+	assert.True(t, errors.Is(err, ErrNoFallback))
+
+	assert.True(t, errors.Is(err, ErrServiceTimeout))
+	assert.Contains(t, err.Error(), "Service timed out after 100 milliseconds")
+}
+
+func TestErrorsTellServiceTimeoutWhenFallbacked(t *testing.T) {
+	cb, _ := createCircuitBreaker(slowService, panickingFallback)
+	cb.Settings.Timeout = 100 * time.Millisecond
+
+	_, fallbacked, err := cb.Call()
+	assert.True(t, fallbacked)
+	assert.True(t, errors.Is(err, ErrServiceTimeout))
+	assert.Contains(t, err.Error(), fallbackPanickedMessage)
+}
+
+func TestErrorsKeepTheCause(t *testing.T) {
+	cb, _ := createCircuitBreaker(failingService, fallback)
+
+	_, fallbacked, err := cb.Call()
+	assert.True(t, fallbacked)
+	assert.True(t, errors.Is(err, failingServiceError))
+
+	var callingErr *CallingError
+	assert.True(t, errors.As(err, &callingErr))
+	assert.Equal(t, failingServiceError, callingErr.Cause)
+	assert.Equal(t, failingServiceError, errors.Unwrap(callingErr))
+}
+
+func TestErrorsTellBulkheadFull(t *testing.T) {
+	var hits int32
+	release := make(chan struct{})
+	defer close(release)
+	cb, _ := NewCircuitBreaker(CircuitSettings{
+		Service:            createBlockedService(&hits, release),
+		Fallback:           fallback,
+		MaxConcurrentCalls: 1,
+	})
+	go cb.Call()
+	for atomic.LoadInt32(&hits) < 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	_, fallbacked, err := cb.Call()
+	assert.True(t, fallbacked)
+	assert.True(t, errors.Is(err, ErrBulkheadFull))
+	assert.False(t, errors.Is(err, ErrNoFallback))
+
+	cb.Settings.Fallback = nil
+	_, fallbacked, err = cb.Call()
+	assert.False(t, fallbacked)
+	assert.True(t, errors.Is(err, ErrBulkheadFull))
+	assert.True(t, errors.Is(err, ErrNoFallback))
+}
+
+func TestOnSuccessShouldFireOnEveryHealthyCall(t *testing.T) {
+	var latencies []time.Duration
+	cb, _ := createCircuitBreaker(createSleepyService(50*time.Millisecond), fallback)
+	cb.Settings.OnSuccess = func(latency time.Duration) {
+		latencies = append(latencies, latency)
+	}
+
+	cb.Call()
+	cb.Call()
+	cb.Settings.Service = failingService
+	cb.Call()
+	assert.Equal(t, 2, len(latencies))
+	for _, latency := range latencies {
+		assert.GreaterOrEqual(t, latency, 50*time.Millisecond)
+		assert.Less(t, latency, cb.Settings.Timeout)
+	}
+}
+
+func TestOnRejectShouldFireOnlyWhenOpen(t *testing.T) {
+	rejectCount := 0
+	cb, _ := createCircuitBreaker(failingService, fallback)
+	cb.Settings.OnReject = func() {
+		rejectCount++
+	}
+
+	for i := 0; i < cb.Settings.FailureThreshold; i++ {
+		cb.Call()
+	}
+	assert.Equal(t, IsOpen, cb.State())
+	assert.Equal(t, 0, rejectCount)
+
+	for i := 0; i < 3; i++ {
+		cb.Call()
+	}
+	assert.Equal(t, 3, rejectCount)
+
+	cb.Settings.FailFast = true
+	cb.Call()
+	assert.Equal(t, 4, rejectCount)
+}
+
+func TestOnFailureShouldFireOnEveryFailure(t *testing.T) {
+	var failures []error
+	cb, _ := createCircuitBreaker(failingService, fallback)
+	cb.Settings.FailureThreshold = 4
+	cb.Settings.Timeout = 100 * time.Millisecond
+	cb.Settings.OnFailure = func(err error) {
+		failures = append(failures, err)
+	}
+
+	cb.Call()
+	cb.Settings.Service = slowService
+	cb.Call()
+	cb.Settings.Service = panickingService
+	cb.Call()
+	cb.Settings.Service = healthService
+	cb.Call()
+	assert.Equal(t, 3, len(failures))
+	assert.Equal(t, failingServiceError, failures[0])
+	assert.True(t, errors.Is(failures[1], ErrServiceTimeout))
+	assert.Contains(t, failures[2].Error(), servicePanickedMessage)
+
+	cb.Settings.Service = failingService
+	for i := 0; i < cb.Settings.FailureThreshold+2; i++ {
+		cb.Call()
+	}
+	// no more than it takes to trip, the rest did not even call the service
+	assert.Equal(t, 3+cb.Settings.FailureThreshold, len(failures))
+	for _, err := range failures[3:] {
+		assert.Equal(t, failingServiceError, err)
+	}
+}
+
+func TestServiceCallIsCancelledByContext(t *testing.T) {
+	cb, _ := createCircuitBreaker(slowService, fallback)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+
+	start := time.Now()
+	res, fallbacked, err := cb.CallContext(ctx)
+	assert.Less(t, time.Since(start), cb.Settings.Timeout)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), context.Canceled.Error())
+	assert.True(t, fallbacked)
+	assert.Equal(t, fallbackContent, res)
+	assert.Equal(t, 1, cb.CurrentFailureCount())
+}
+
+func TestCallWithCancelReturnsPromptly(t *testing.T) {
+	cb, _ := createCircuitBreaker(slowService, fallback)
+
+	done := make(chan struct{})
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		close(done)
+	}()
+
+	start := time.Now()
+	res, fallbacked, err := cb.CallWithCancel(done)
+	assert.Less(t, time.Since(start), cb.Settings.Timeout/2)
+	assert.True(t, errors.Is(err, ErrCallCancelled))
+	assert.Contains(t, err.Error(), "Call was cancelled")
+	assert.True(t, fallbacked)
+	assert.Equal(t, fallbackContent, res)
+	assert.Equal(t, 1, cb.CurrentFailureCount())
+}
+
+func TestCallWithCancelNeverDone(t *testing.T) {
+	cb, _ := createCircuitBreaker(healthService, fallback)
+
+	res, fallbacked, err := cb.CallWithCancel(make(chan struct{}))
+	assert.Equal(t, healthServiceContent, res)
+	assert.False(t, fallbacked)
+	assert.Nil(t, err)
+}
+
+func TestResponseRightAtTimeoutIsNotMasked(t *testing.T) {
+	release := make(chan struct{})
+	responded := make(chan struct{})
+	cb, _ := createCircuitBreaker(func() (interface{}, error) {
+		<-release
+		defer close(responded)
+		return nil, failingServiceError
+	}, fallback)
+	cb.Settings.Timeout = 50 * time.Millisecond
+
+	result := make(chan error, 1)
+	go func() {
+		_, _, err := cb.Call()
+		result <- err
+	}()
+
+	// the timeout goes off while the circuit is busy, meanwhile the service
+	// gets to respond, so both are there once it gets to look at them
+	time.Sleep(10 * time.Millisecond)
+	cb.mutex.Lock()
+	time.Sleep(2 * cb.Settings.Timeout)
+	close(release)
+	<-responded
+	time.Sleep(10 * time.Millisecond)
+	cb.mutex.Unlock()
+
+	err := <-result
+	assert.True(t, errors.Is(err, failingServiceError))
+	assert.False(t, errors.Is(err, ErrServiceTimeout))
+	assert.Equal(t, int64(0), cb.Metrics().TotalTimeouts)
+	assert.Equal(t, 0, cb.TimeoutCount())
+}
+
+func TestContextDeadlineShorterThanTimeout(t *testing.T) {
+	cb, _ := createCircuitBreaker(slowService, fallback)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, fallbacked, err := cb.CallContext(ctx)
+	assert.Less(t, time.Since(start), cb.Settings.Timeout/2)
+	assert.True(t, fallbacked)
+	assert.True(t, errors.Is(err, ErrServiceTimeout) || errors.Is(err, context.DeadlineExceeded))
+	assert.Equal(t, 1, cb.CurrentFailureCount())
+}
+
+func TestContextDeadlineLongerThanTimeout(t *testing.T) {
+	cb, _ := createCircuitBreaker(slowService, fallback)
+	cb.Settings.Timeout = 100 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	_, fallbacked, err := cb.CallContext(ctx)
+	assert.True(t, fallbacked)
+	assert.True(t, errors.Is(err, ErrServiceTimeout))
+	assert.Contains(t, err.Error(), "Service timed out after 100 milliseconds")
+}
+
+func TestExpiredContextDoesNotCallService(t *testing.T) {
+	var hits int32
+	release := make(chan struct{})
+	close(release)
+	cb, _ := createCircuitBreaker(createBlockedService(&hits, release), fallback)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, fallbacked, err := cb.CallContext(ctx)
+	assert.True(t, fallbacked)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&hits))
+}
+
+func TestFallbackIsSkippedWhenContextIsNearlyExpired(t *testing.T) {
+	fallbackHits := 0
+	cb, _ := createCircuitBreaker(slowService, func() (interface{}, error) {
+		fallbackHits++
+		return fallbackContent, nil
+	})
+	cb.Settings.FallbackMinTime = time.Second
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	res, fallbacked, err := cb.CallContext(ctx)
+	assert.Nil(t, res)
+	assert.False(t, fallbacked)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.False(t, errors.Is(err, ErrNoFallback))
+	assert.Equal(t, 0, fallbackHits)
+	// the service failed all the same
+	assert.Equal(t, 1, cb.CurrentFailureCount())
+}
+
+func TestFallbackIsCalledWhenContextHasTimeLeft(t *testing.T) {
+	cb, _ := createCircuitBreaker(failingService, fallback)
+	cb.Settings.FallbackMinTime = 100 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	res, fallbacked, err := cb.CallContext(ctx)
+	assert.Equal(t, fallbackContent, res)
+	assert.True(t, fallbacked)
+	assert.True(t, errors.Is(err, failingServiceError))
+}
+
+func TestFallbackIsSkippedWhileOpenWhenContextIsNearlyExpired(t *testing.T) {
+	cb, _ := createCircuitBreaker(healthService, fallback)
+	cb.Settings.FallbackMinTime = time.Second
+	cb.Trip()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	res, fallbacked, err := cb.CallContext(ctx)
+	assert.Nil(t, res)
+	assert.False(t, fallbacked)
+	assert.True(t, errors.Is(err, ErrCircuitOpen))
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	// with no deadline at all there is always time for it
+	res, fallbacked, _ = cb.Call()
+	assert.Equal(t, fallbackContent, res)
+	assert.True(t, fallbacked)
+}
+
+func TestCircuitShouldOpenWhenReachThreashold(t *testing.T) {
+	cb, _ := createCircuitBreaker(slowService, fallback)
+	assert.Equal(t, IsClosed, cb.State())
+
+	cb.Settings.OnTrip = func() {
+		assert.Equal(t, IsOpen, cb.State())
+	}
+	cb.Settings.OnReset = func() {
+		assert.Fail(t, "Should not reset")
+	}
+
+	for i := 0; i < cb.Settings.FailureThreshold; i++ {
+		// closed before each call, as it is the last one that trips it
+		assert.Equal(t, IsClosed, cb.State())
+		res, fallbacked, err := cb.Call()
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), fallbackDueToErrorMessage)
+		assert.True(t, fallbacked)
+		assert.Contains(t, fallbackContent, res)
+	}
+	assert.Equal(t, IsOpen, cb.State())
+}
+
+func assertTripsOnNthFailure(t *testing.T, threshold int) {
+	cb, _ := createCircuitBreaker(failingService, fallback)
+	cb.Settings.FailureThreshold = threshold
+
+	for i := 1; i < threshold; i++ {
+		cb.Call()
+		assert.Equal(t, i, cb.CurrentFailureCount())
+		assert.Equal(t, IsClosed, cb.State(), "still closed after %d of %d fails", i, threshold)
+	}
+	cb.Call()
+	assert.Equal(t, threshold, cb.CurrentFailureCount())
+	assert.Equal(t, IsOpen, cb.State(), "open right on fail %d of %d", threshold, threshold)
+}
+
+func TestThresholdOfOneTripsOnFirstFailure(t *testing.T) {
+	assertTripsOnNthFailure(t, 1)
+}
+
+func TestThresholdOfThreeTripsOnThirdFailure(t *testing.T) {
+	assertTripsOnNthFailure(t, 3)
+}
+
+func TestThresholdOfDefaultTripsOnSecondFailure(t *testing.T) {
+	assertTripsOnNthFailure(t, DefautlFailureThreshold)
+}
+
+func TestCircuitShouldHalfOpenAfterRetryTimePeriod(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := createCircuitBreakerWithClock(slowService, fallback, clock)
+	assert.Equal(t, IsClosed, cb.State())
+
+	for i := 0; i < cb.Settings.FailureThreshold; i++ {
+		// still closed while inside failure threashold
+		assert.Equal(t, IsClosed, cb.State())
+		//
+		res, fallbacked, err := cb.Call()
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), fallbackDueToErrorMessage)
+		assert.True(t, fallbacked)
+		assert.Contains(t, fallbackContent, res)
+	}
+	// should trip after reach failure threashold
+	assert.Equal(t, IsOpen, cb.State())
+
+	// wait something to benefit from a half-open state due to retry time period
+	clock.Advance(cb.Settings.RetryTimePeriod + time.Nanosecond)
+	assert.Equal(t, IsHalfOpen, cb.State())
+
+	res, fallbacked, err := cb.Call()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), fallbackDueToErrorMessage)
+	assert.True(t, fallbacked)
+	assert.Contains(t, fallbackContent, res)
+	assert.Equal(t, IsOpen, cb.State())
+}
+
+func TestCircuitShouldStayOpenDuringRetryTimePeriod(t *testing.T) {
+	cb, _ := createCircuitBreakerWithRetryTimePeriod(failingService, fallback, 500*time.Millisecond)
+
+	for i := 0; i < cb.Settings.FailureThreshold; i++ {
+		cb.Call()
+	}
+	assert.Equal(t, IsOpen, cb.State())
+
+	// halfway through retry time period it is still open
+	time.Sleep(250 * time.Millisecond)
+	assert.Equal(t, IsOpen, cb.State())
+
+	// only once retry time period is over we give it a chance
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, IsHalfOpen, cb.State())
+}
+
+func TestCircuitShouldHalfOpenAfterRetryTimePeriodOnClock(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := createCircuitBreakerWithClock(failingService, fallback, clock)
+
+	for i := 0; i < cb.Settings.FailureThreshold; i++ {
+		cb.Call()
+	}
+	assert.Equal(t, IsOpen, cb.State())
+	assert.Equal(t, clock.Now(), cb.LastFailureTime)
+
+	clock.Advance(cb.Settings.RetryTimePeriod)
+	assert.Equal(t, IsOpen, cb.State())
+
+	clock.Advance(time.Nanosecond)
+	assert.Equal(t, IsHalfOpen, cb.State())
+
+	cb.Settings.Service = healthService
+	cb.Call()
+	assert.Equal(t, IsClosed, cb.State())
+}
+
+func TestProbeWithoutFallbackShouldGetServiceError(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := createCircuitBreakerWithClock(failingService, fallback, clock)
+	cb.Settings.ProbeWithoutFallback = true
+
+	for i := 0; i < cb.Settings.FailureThreshold; i++ {
+		cb.Call()
+	}
+	clock.Advance(cb.Settings.RetryTimePeriod + time.Nanosecond)
+	assert.Equal(t, IsHalfOpen, cb.State())
+
+	res, fallbacked, err := cb.Call()
+	assert.False(t, fallbacked)
+	assert.Nil(t, res)
+	assert.True(t, errors.Is(err, failingServiceError))
+	assert.NotContains(t, err.Error(), fallbackDueToErrorMessage)
+	assert.Equal(t, int64(cb.Settings.FailureThreshold+1), cb.Metrics().TotalFailures)
+	assert.Equal(t, IsOpen, cb.State())
+
+	// while open, it is the fallback as usual
+	res, fallbacked, err = cb.Call()
+	assert.True(t, fallbacked)
+	assert.Equal(t, fallbackContent, res)
+	assert.True(t, errors.Is(err, ErrCircuitOpen))
+
+	clock.Advance(cb.Settings.RetryTimePeriod + time.Nanosecond)
+	cb.Settings.Service = healthService
+	res, fallbacked, err = cb.Call()
+	assert.Nil(t, err)
+	assert.False(t, fallbacked)
+	assert.Equal(t, healthServiceContent, res)
+	assert.Equal(t, IsClosed, cb.State())
+}
+
+func TestCircuitShouldCloseOnlyAfterSuccessThreshold(t *testing.T) {
+	cb, _ := createCircuitBreakerWithRetryTimePeriod(failingService, fallback, 100*time.Millisecond)
+	cb.Settings.SuccessThreshold = 3
+
+	for i := 0; i < cb.Settings.FailureThreshold; i++ {
+		cb.Call()
+	}
+	assert.Equal(t, IsOpen, cb.State())
+
+	time.Sleep(cb.Settings.RetryTimePeriod)
+	cb.Settings.Service = healthService
+
+	for i := 1; i < cb.Settings.SuccessThreshold; i++ {
+		// still probing while inside success threshold
+		res, fallbacked, err := cb.Call()
+		assert.Nil(t, err)
+		assert.False(t, fallbacked)
+		assert.Equal(t, healthServiceContent, res)
+		assert.Equal(t, i, cb.SuccessCount)
+		assert.Equal(t, IsHalfOpen, cb.State())
+	}
+
+	cb.Call()
+	assert.Equal(t, IsClosed, cb.State())
+	assert.Equal(t, 0, cb.CurrentFailureCount())
+	assert.Equal(t, 0, cb.SuccessCount)
+}
+
+func TestCircuitShouldReopenOnFailureWhileHalfOpen(t *testing.T) {
+	cb, _ := createCircuitBreakerWithRetryTimePeriod(failingService, fallback, 100*time.Millisecond)
+	cb.Settings.SuccessThreshold = 3
+
+	for i := 0; i < cb.Settings.FailureThreshold; i++ {
+		cb.Call()
+	}
+	time.Sleep(cb.Settings.RetryTimePeriod)
+
+	cb.Settings.Service = healthService
+	cb.Call()
+	assert.Equal(t, 1, cb.SuccessCount)
+	assert.Equal(t, IsHalfOpen, cb.State())
+
+	cb.Settings.Service = failingService
+	cb.Call()
+	assert.Equal(t, 0, cb.SuccessCount)
+	assert.Equal(t, IsOpen, cb.State())
+}
+
+func TestOpenStateCallsShouldNotCountAsFailures(t *testing.T) {
+	cb, _ := createCircuitBreakerWithRetryTimePeriod(failingService, fallback, 200*time.Millisecond)
+
+	for i := 0; i < cb.Settings.FailureThreshold; i++ {
+		cb.Call()
+	}
+	assert.Equal(t, IsOpen, cb.State())
+	lastFailureTime := cb.LastFailureTime
+
+	for i := 0; i < 5; i++ {
+		time.Sleep(20 * time.Millisecond)
+		res, fallbacked, err := cb.Call()
+		assert.Contains(t, err.Error(), fallbackDueToOpenStateMessage)
+		assert.True(t, fallbacked)
+		assert.Equal(t, fallbackContent, res)
+	}
+	assert.Equal(t, lastFailureTime, cb.LastFailureTime)
+	assert.Equal(t, cb.Settings.FailureThreshold, cb.CurrentFailureCount())
+	assert.Equal(t, cb.Settings.FailureThreshold, len(cb.FailureRecord))
+
+	// open calls didn't push the chance further away
+	time.Sleep(cb.Settings.RetryTimePeriod - time.Since(lastFailureTime))
+	assert.Equal(t, IsHalfOpen, cb.State())
+}
+
+func TestOnHalfOpenShouldFireOnceRetryTimePeriodIsOver(t *testing.T) {
+	cb, _ := createCircuitBreakerWithRetryTimePeriod(failingService, fallback, 100*time.Millisecond)
+
+	halfOpenCount := 0
+	cb.Settings.OnHalfOpen = func() {
+		halfOpenCount++
+		assert.Equal(t, IsHalfOpen, cb.State())
+	}
+
+	for i := 0; i < cb.Settings.FailureThreshold; i++ {
+		cb.Call()
+	}
+	for i := 0; i < 3; i++ {
+		// calls on open state are no reason for it
+		cb.Call()
+		assert.Equal(t, 0, halfOpenCount)
+	}
+
+	time.Sleep(cb.Settings.RetryTimePeriod)
+	cb.Call()
+	assert.Equal(t, 1, halfOpenCount)
+	assert.Equal(t, IsOpen, cb.State())
+
+	for i := 0; i < 3; i++ {
+		// neither it is on open state after a failed chance
+		cb.Call()
+		assert.Equal(t, 1, halfOpenCount)
+	}
+}
+
+func TestOnTransitionShouldTellFromAndTo(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := createCircuitBreakerWithClock(failingService, fallback, clock)
+
+	type transition struct{ from, to CircuitState }
+	var transitions []transition
+	cb.Settings.OnTransition = func(from, to CircuitState) {
+		transitions = append(transitions, transition{from, to})
+	}
+
+	for i := 0; i < cb.Settings.FailureThreshold; i++ {
+		cb.Call()
+	}
+	assert.Equal(t, []transition{{IsClosed, IsOpen}}, transitions)
+
+	clock.Advance(cb.Settings.RetryTimePeriod)
+	cb.Call()
+	assert.Equal(t, 1, len(transitions))
+
+	clock.Advance(time.Millisecond)
+	cb.Settings.Service = healthService
+	cb.Call()
+	assert.Equal(t, []transition{
+		{IsClosed, IsOpen},
+		{IsOpen, IsHalfOpen},
+		{IsHalfOpen, IsClosed},
+	}, transitions)
+}
+
+func TestCallbackMinIntervalCoalescesFlapping(t *testing.T) {
+	cb, _ := createCircuitBreaker(healthService, fallback)
+	cb.Settings.CallbackMinInterval = 100 * time.Millisecond
+
+	var changes int32
+	cb.Settings.OnStateChange = func() {
+		atomic.AddInt32(&changes, 1)
+	}
+	type transition struct{ from, to CircuitState }
+	var mutex sync.Mutex
+	var transitions []transition
+	cb.Settings.OnTransition = func(from, to CircuitState) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		transitions = append(transitions, transition{from, to})
+	}
+
+	for i := 0; i < 20; i++ {
+		cb.Trip()
+		cb.Reset()
+	}
+	// the first one is told right away, the rest has to wait
+	assert.Equal(t, int32(1), atomic.LoadInt32(&changes))
+	assert.Equal(t, 40, len(cb.History()))
+
+	time.Sleep(150 * time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&changes))
+	mutex.Lock()
+	assert.Equal(t, []transition{{IsClosed, IsOpen}, {IsOpen, IsClosed}}, transitions)
+	mutex.Unlock()
+}
+
+func TestCallbackMinIntervalSkipsRoundTrips(t *testing.T) {
+	cb, _ := createCircuitBreaker(healthService, fallback)
+	cb.Settings.CallbackMinInterval = 50 * time.Millisecond
+
+	var trips int32
+	cb.Settings.OnTrip = func() {
+		atomic.AddInt32(&trips, 1)
+	}
+	var resets int32
+	cb.Settings.OnReset = func() {
+		atomic.AddInt32(&resets, 1)
+	}
+
+	cb.Trip()
+	cb.Reset()
+	cb.Trip()
+	time.Sleep(100 * time.Millisecond)
+	// it ended up open, which is what it was last told about
+	assert.Equal(t, int32(1), atomic.LoadInt32(&trips))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&resets))
+
+	cb.Reset()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&resets))
+}
+
+func TestNoCallbackMinIntervalByDefault(t *testing.T) {
+	cb, _ := createCircuitBreaker(healthService, fallback)
+
+	changes := 0
+	cb.Settings.OnStateChange = func() {
+		changes++
+	}
+	for i := 0; i < 20; i++ {
+		cb.Trip()
+		cb.Reset()
+	}
+	assert.Equal(t, 40, changes)
+}
+
+func TestHalfOpenTimeoutIsShorter(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := createCircuitBreakerWithClock(slowService, fallback, clock)
+	cb.Settings.Timeout = 500 * time.Millisecond
+	cb.Settings.HalfOpenTimeout = 50 * time.Millisecond
+	cb.Trip()
+	clock.Advance(cb.Settings.RetryTimePeriod + time.Millisecond)
+	assert.Equal(t, IsHalfOpen, cb.State())
+
+	start := time.Now()
+	_, fallbacked, err := cb.Call()
+	assert.Less(t, time.Since(start), cb.Settings.Timeout/2)
+	assert.True(t, fallbacked)
+	assert.Contains(t, err.Error(), "Service timed out after 50 milliseconds")
+	assert.Equal(t, IsOpen, cb.State())
+}
+
+func TestHalfOpenTimeoutIsNotForClosed(t *testing.T) {
+	cb, _ := createCircuitBreaker(slowService, fallback)
+	cb.Settings.Timeout = 100 * time.Millisecond
+	cb.Settings.HalfOpenTimeout = 10 * time.Millisecond
+
+	_, _, err := cb.Call()
+	assert.Contains(t, err.Error(), "Service timed out after 100 milliseconds")
+}
+
+func TestCallbacksMayLookIntoCircuit(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := createCircuitBreakerWithClock(failingService, fallback, clock)
+
+	var states []CircuitState
+	lookInto := func() {
+		states = append(states, cb.State())
+		cb.Metrics()
+		cb.CurrentFailureCount()
+		cb.TimeUntilHalfOpen()
+		cb.History()
+		cb.Failures()
+	}
+	cb.Settings.OnStateChange = lookInto
+	cb.Settings.OnTrip = lookInto
+	cb.Settings.OnHalfOpen = lookInto
+	cb.Settings.OnReset = lookInto
+	cb.Settings.OnReject = lookInto
+	cb.Settings.OnTransition = func(CircuitState, CircuitState) { lookInto() }
+	cb.Settings.OnFailure = func(error) { lookInto() }
+	cb.Settings.OnSuccess = func(time.Duration) { lookInto() }
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < cb.Settings.FailureThreshold+1; i++ {
+			cb.Call()
+		}
+		clock.Advance(cb.Settings.RetryTimePeriod + time.Millisecond)
+		cb.Settings.Service = healthService
+		cb.Call()
+		cb.Trip()
+		cb.Reset()
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		assert.FailNow(t, "Callbacks got the circuit stuck")
+	}
+	assert.Contains(t, states, IsOpen)
+	assert.Contains(t, states, IsHalfOpen)
+	assert.Equal(t, IsClosed, states[len(states)-1])
+}
+
+func TestFailuresUntilTripDecrements(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := createCircuitBreakerWithClock(failingService, fallback, clock)
+	cb.Settings.FailureThreshold = 3
+	assert.Equal(t, 3, cb.FailuresUntilTrip())
+
+	cb.Call()
+	assert.Equal(t, 2, cb.FailuresUntilTrip())
+	cb.Call()
+	assert.Equal(t, 1, cb.FailuresUntilTrip())
+	cb.Call()
+	assert.Equal(t, IsOpen, cb.State())
+	assert.Equal(t, 0, cb.FailuresUntilTrip())
+
+	clock.Advance(cb.Settings.RetryTimePeriod + time.Millisecond)
+	assert.Equal(t, IsHalfOpen, cb.State())
+	assert.Equal(t, 1, cb.FailuresUntilTrip())
+
+	cb.Settings.Service = healthService
+	cb.Call()
+	assert.Equal(t, 3, cb.FailuresUntilTrip())
+}
+
+func TestFailuresUntilTripWithinWindow(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := createCircuitBreakerWithClock(failingService, fallback, clock)
+	cb.Settings.FailureThreshold = 3
+	cb.Settings.WindowDuration = time.Minute
+
+	cb.Call()
+	cb.Call()
+	assert.Equal(t, 1, cb.FailuresUntilTrip())
+
+	// those fails are way too old to count by now
+	clock.Advance(2 * time.Minute)
+	assert.Equal(t, 3, cb.FailuresUntilTrip())
+}
+
+func createAdaptiveCircuitBreaker() *CircuitBreaker {
+	cb, _ := createCircuitBreaker(healthService, fallback)
+	cb.Settings.RollingWindowSize = 20
+	// a quarter of the recent calls, but never less than two
+	cb.Settings.ThresholdFunc = func(recentRequests int) int {
+		return max(2, recentRequests/4)
+	}
+	return cb
+}
+
+func TestFailuresUntilTripInPercentageMode(t *testing.T) {
+	cb, _ := createCircuitBreaker(healthService, fallback)
+	cb.Settings.ErrorPercentThreshold = 50
+	cb.Settings.RollingWindowSize = 4
+	// too few calls to tell, so it takes enough of them, all failing
+	assert.Equal(t, 4, cb.FailuresUntilTrip())
+
+	for i := 0; i < cb.Settings.RollingWindowSize; i++ {
+		cb.Call()
+	}
+	// every fail pushes a success out of the window, so 3 of 4 will do
+	assert.Equal(t, 3, cb.FailuresUntilTrip())
+
+	cb.Settings.Service = failingService
+	for i := 3; i > 0; i-- {
+		assert.Equal(t, i, cb.FailuresUntilTrip())
+		assert.Equal(t, IsClosed, cb.State())
+		cb.Call()
+	}
+	assert.Equal(t, IsOpen, cb.State())
+	assert.Equal(t, 0, cb.FailuresUntilTrip())
+}
+
+func TestThresholdFuncWhileQuiet(t *testing.T) {
+	cb := createAdaptiveCircuitBreaker()
+	assert.Equal(t, 2, cb.FailuresUntilTrip())
+
+	cb.Settings.Service = failingService
+	cb.Call()
+	assert.Equal(t, IsClosed, cb.State())
+	cb.Call()
+	assert.Equal(t, IsOpen, cb.State())
+}
+
+func TestThresholdFuncWhileBusy(t *testing.T) {
+	cb := createAdaptiveCircuitBreaker()
+	for i := 0; i < 16; i++ {
+		cb.Call()
+	}
+	assert.Equal(t, 4, cb.FailuresUntilTrip())
+
+	cb.Settings.Service = failingService
+	for i := 0; i < 4; i++ {
+		cb.Call()
+		assert.Equal(t, IsClosed, cb.State())
+	}
+	// the window is full by now, so it takes five
+	assert.Equal(t, 1, cb.FailuresUntilTrip())
+	cb.Call()
+	assert.Equal(t, IsOpen, cb.State())
+	assert.Equal(t, 5, cb.CurrentFailureCount())
+}
+
+func TestThresholdFuncIsAtLeastOne(t *testing.T) {
+	cb, _ := createCircuitBreaker(failingService, fallback)
+	cb.Settings.RollingWindowSize = 10
+	cb.Settings.ThresholdFunc = func(int) int { return 0 }
+	assert.Equal(t, 1, cb.FailuresUntilTrip())
+
+	cb.Call()
+	assert.Equal(t, IsOpen, cb.State())
+}
+
+func TestThresholdFuncWithoutRollingWindowIsRejected(t *testing.T) {
+	cb, err := NewCircuitBreaker(CircuitSettings{
+		Service:       healthService,
+		ThresholdFunc: func(int) int { return 2 },
+	})
+	assert.Nil(t, cb)
+	assert.NotNil(t, err)
+}
+
+func TestThresholdFuncMissedChanceKeepsItOpen(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := createCircuitBreakerWithClock(failingService, fallback, clock)
+	cb.Settings.RollingWindowSize = 20
+	// a steep one, so every probe makes it take way more fails
+	cb.Settings.ThresholdFunc = func(recentRequests int) int {
+		return 2 + 10*recentRequests
+	}
+
+	cb.Trip()
+	for i := 0; i < 3; i++ {
+		clock.Advance(cb.Settings.RetryTimePeriod + time.Millisecond)
+		assert.Equal(t, IsHalfOpen, cb.State())
+		cb.Call()
+		assert.Equal(t, IsOpen, cb.State())
+	}
+}
+
+func TestTripAfterInPercentageMode(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := createCircuitBreakerWithClock(failingService, fallback, clock)
+	cb.Settings.ErrorPercentThreshold = 50
+	cb.Settings.RollingWindowSize = 100
+	cb.Settings.TripAfter = time.Minute
+
+	// far from a full window, but it never stops failing
+	for i := 0; i < 2; i++ {
+		cb.Call()
+		assert.Equal(t, IsClosed, cb.State())
+		clock.Advance(30 * time.Second)
+	}
+	cb.Call()
+	assert.Equal(t, IsOpen, cb.State())
+}
+
+func TestTripAfterSustainedFailures(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := createCircuitBreakerWithClock(failingService, fallback, clock)
+	cb.Settings.FailureThreshold = 100
+	cb.Settings.TripAfter = time.Minute
+
+	// a fail every 20 seconds is far from the threshold, but it never stops
+	for i := 0; i < 3; i++ {
+		cb.Call()
+		assert.Equal(t, IsClosed, cb.State())
+		clock.Advance(20 * time.Second)
+	}
+	cb.Call()
+	assert.Equal(t, IsOpen, cb.State())
+	assert.Equal(t, 4, cb.CurrentFailureCount())
+}
+
+func TestTripAfterStreakIsBrokenBySuccess(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := createCircuitBreakerWithClock(failingService, fallback, clock)
+	cb.Settings.FailureThreshold = 100
+	cb.Settings.TripAfter = time.Minute
+
+	for i := 0; i < 3; i++ {
+		cb.Call()
+		clock.Advance(20 * time.Second)
+	}
+	cb.Settings.Service = healthService
+	cb.Call()
+	cb.Settings.Service = failingService
+	for i := 0; i < 3; i++ {
+		cb.Call()
+		assert.Equal(t, IsClosed, cb.State())
+		clock.Advance(20 * time.Second)
+	}
+	cb.Call()
+	assert.Equal(t, IsOpen, cb.State())
+}
+
+func TestTripAfterNeedsFailuresAllAlong(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := createCircuitBreakerWithClock(failingService, fallback, clock)
+	cb.Settings.FailureThreshold = 100
+	cb.Settings.TripAfter = time.Minute
+
+	// just time going by is no sign of anything
+	cb.Call()
+	clock.Advance(time.Hour)
+	assert.Equal(t, IsClosed, cb.State())
+}
+
+func TestTripAfterThenHalfOpen(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := createCircuitBreakerWithClock(failingService, fallback, clock)
+	cb.Settings.FailureThreshold = 100
+	cb.Settings.TripAfter = time.Minute
+
+	cb.Call()
+	clock.Advance(time.Minute)
+	cb.Call()
+	assert.Equal(t, IsOpen, cb.State())
+
+	clock.Advance(cb.Settings.RetryTimePeriod + time.Millisecond)
+	assert.Equal(t, IsHalfOpen, cb.State())
+	cb.Call()
+	assert.Equal(t, IsOpen, cb.State())
+
+	clock.Advance(cb.Settings.RetryTimePeriod + time.Millisecond)
+	cb.Settings.Service = healthService
+	cb.Call()
+	assert.Equal(t, IsClosed, cb.State())
+}
+
+func TestSnapshotOfNewCircuitBreaker(t *testing.T) {
+	cb, _ := createCircuitBreaker(healthService, fallback)
+
+	state, failures, lastFailure := cb.Snapshot()
+	assert.Equal(t, IsClosed, state)
+	assert.Equal(t, 0, failures)
+	assert.True(t, lastFailure.IsZero())
+}
+
+func TestSnapshotReflectsCalls(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := createCircuitBreakerWithClock(failingService, fallback, clock)
+
+	cb.Call()
+	state, failures, lastFailure := cb.Snapshot()
+	assert.Equal(t, IsClosed, state)
+	assert.Equal(t, 1, failures)
+	assert.Equal(t, clock.Now(), lastFailure)
+
+	clock.Advance(time.Second)
+	cb.Call()
+	state, failures, lastFailure = cb.Snapshot()
+	assert.Equal(t, IsOpen, state)
+	assert.Equal(t, 2, failures)
+	assert.Equal(t, clock.Now(), lastFailure)
+}
+
+func TestSnapshotChangesNothing(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := createCircuitBreakerWithClock(failingService, fallback, clock)
+	cb.Settings.IdleResetTimeout = time.Minute
+	transitions := 0
+	cb.Settings.OnStateChange = func() {
+		transitions++
+	}
+
+	cb.Call()
+	metrics := cb.Metrics()
+	clock.Advance(time.Hour)
+	for i := 0; i < 3; i++ {
+		_, failures, _ := cb.Snapshot()
+		assert.Equal(t, 1, failures)
+	}
+	assert.Equal(t, metrics, cb.Metrics())
+	assert.Equal(t, 0, transitions)
+	assert.Empty(t, cb.History())
+}
+
+func TestFailureCountStaysBoundedAcrossProbes(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := createCircuitBreakerWithClock(failingService, fallback, clock)
+
+	for i := 0; i < cb.Settings.FailureThreshold; i++ {
+		cb.Call()
+	}
+	for i := 0; i < 100; i++ {
+		clock.Advance(cb.Settings.RetryTimePeriod + time.Millisecond)
+		assert.Equal(t, IsHalfOpen, cb.State())
+		cb.Call()
+		assert.Equal(t, IsOpen, cb.State())
+	}
+	assert.Equal(t, cb.Settings.FailureThreshold, cb.CurrentFailureCount())
+	assert.Equal(t, cb.Settings.FailureThreshold, len(cb.FailureRecord))
+	assert.Equal(t, int64(cb.Settings.FailureThreshold+100), cb.Metrics().TotalFailures)
+
+	// and so does the count of timeouts among them
+	cb.Settings.Service = createSleepyService(20 * time.Millisecond)
+	cb.Settings.Timeout = time.Millisecond
+	for i := 0; i < 20; i++ {
+		clock.Advance(cb.Settings.RetryTimePeriod + time.Millisecond)
+		cb.Call()
+		assert.Equal(t, IsOpen, cb.State())
+	}
+	assert.Equal(t, cb.Settings.FailureThreshold, cb.CurrentFailureCount())
+	assert.Equal(t, cb.Settings.FailureThreshold, cb.TimeoutCount())
+}
+
+func TestCloneHasSameSettingsButOwnState(t *testing.T) {
+	tripCount := 0
+	cb, _ := createCircuitBreaker(failingService, fallback)
+	cb.Settings.FailureThreshold = 3
+	cb.Settings.OnTrip = func() {
+		tripCount++
+	}
+	cb.Call()
+
+	clone := cb.Clone()
+	assert.Equal(t, 3, clone.Settings.FailureThreshold)
+	assert.Equal(t, 0, clone.CurrentFailureCount())
+	assert.Empty(t, clone.FailureRecord)
+	assert.True(t, clone.LastFailureTime.IsZero())
+
+	for i := 0; i < clone.Settings.FailureThreshold; i++ {
+		clone.Call()
+	}
+	assert.Equal(t, IsOpen, clone.State())
+	assert.Equal(t, 1, tripCount)
+
+	assert.Equal(t, IsClosed, cb.State())
+	assert.Equal(t, 1, cb.CurrentFailureCount())
+	assert.Equal(t, 1, len(cb.FailureRecord))
+}
+
+func TestFailureDebounceCountsBurstOnce(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := createCircuitBreakerWithClock(failingService, fallback, clock)
+	cb.Settings.FailureThreshold = 3
+	cb.Settings.FailureDebounce = 10 * time.Millisecond
+
+	for i := 0; i < 3; i++ {
+		cb.Call()
+		clock.Advance(3 * time.Millisecond)
+	}
+	assert.Equal(t, 1, cb.CurrentFailureCount())
+	assert.Equal(t, 1, len(cb.FailureRecord))
+	assert.Equal(t, int64(3), cb.Metrics().TotalFailures)
+	assert.Equal(t, IsClosed, cb.State())
+
+	// the window goes from the one that counted, not the latest one
+	clock.Advance(time.Millisecond)
+	cb.Call()
+	assert.Equal(t, 2, cb.CurrentFailureCount())
+
+	clock.Advance(10 * time.Millisecond)
+	cb.Call()
+	assert.Equal(t, 3, cb.CurrentFailureCount())
+	assert.Equal(t, IsOpen, cb.State())
+}
+
+func TestFailureDebounceDoesNotSkipFailedProbes(t *testing.T) {
+	clock := newFakeClock()
+	var calls int32
+	cb, _ := createCircuitBreakerWithClock(func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return failingService()
+	}, fallback, clock)
+	cb.Settings.RetryTimePeriod = time.Second
+	cb.Settings.FailureDebounce = 5 * time.Second
+
+	for i := 0; i < cb.Settings.FailureThreshold; i++ {
+		cb.Call()
+		clock.Advance(cb.Settings.FailureDebounce)
+	}
+	assert.Equal(t, IsHalfOpen, cb.State())
+
+	// well within the debounce, yet the missed chance opens it again
+	cb.Call()
+	assert.Equal(t, IsOpen, cb.State())
+	for i := 0; i < 5; i++ {
+		cb.Call()
+	}
+	assert.Equal(t, int32(cb.Settings.FailureThreshold+1), atomic.LoadInt32(&calls))
+
+	clock.Advance(cb.Settings.RetryTimePeriod + time.Millisecond)
+	assert.Equal(t, IsHalfOpen, cb.State())
+	cb.Call()
+	assert.Equal(t, IsOpen, cb.State())
+}
+
+func TestFailureDebounceDisabledByDefault(t *testing.T) {
+	cb, _ := createCircuitBreaker(failingService, fallback)
+	cb.Settings.FailureThreshold = 3
+
+	for i := 0; i < 3; i++ {
+		cb.Call()
+	}
+	assert.Equal(t, 3, cb.CurrentFailureCount())
+}
+
+func weighTimeouts(err error) int {
+	if errors.Is(err, ErrServiceTimeout) {
+		return 3
+	}
+	return 1
+}
+
+func TestFailureWeightTripsFasterOnHeavyFails(t *testing.T) {
+	light, _ := createCircuitBreaker(failingService, fallback)
+	light.Settings.FailureThreshold = 6
+	light.Settings.FailureWeight = weighTimeouts
+
+	heavy, _ := createCircuitBreaker(createSleepyService(50*time.Millisecond), fallback)
+	heavy.Settings.FailureThreshold = 6
+	heavy.Settings.Timeout = 5 * time.Millisecond
+	heavy.Settings.FailureWeight = weighTimeouts
+
+	for i := 0; i < 2; i++ {
+		light.Call()
+		heavy.Call()
+	}
+	assert.Equal(t, 2, light.CurrentFailureCount())
+	assert.Equal(t, IsClosed, light.State())
+	assert.Equal(t, 6, heavy.CurrentFailureCount())
+	assert.Equal(t, 6, len(heavy.FailureRecord))
+	assert.Equal(t, IsOpen, heavy.State())
+
+	for i := 0; i < 4; i++ {
+		light.Call()
+	}
+	assert.Equal(t, IsOpen, light.State())
+}
+
+func TestFailureWeightIsAtLeastOne(t *testing.T) {
+	cb, _ := createCircuitBreaker(failingService, fallback)
+	cb.Settings.FailureWeight = func(error) int { return 0 }
+
+	cb.Call()
+	assert.Equal(t, 1, cb.CurrentFailureCount())
+}
+
+func TestIdleResetTimeoutForgetsFailures(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := createCircuitBreakerWithClock(failingService, fallback, clock)
+	cb.Settings.FailureThreshold = 3
+	cb.Settings.IdleResetTimeout = time.Minute
+
+	cb.Call()
+	cb.Call()
+	assert.Equal(t, 2, cb.CurrentFailureCount())
+
+	clock.Advance(59 * time.Second)
+	assert.Equal(t, IsClosed, cb.State())
+	assert.Equal(t, 2, cb.CurrentFailureCount())
+
+	clock.Advance(time.Second)
+	assert.Equal(t, IsClosed, cb.State())
+	assert.Equal(t, 0, cb.CurrentFailureCount())
+	assert.Empty(t, cb.Failures())
+
+	// so it takes a whole new set of fails to trip
+	cb.Call()
+	cb.Call()
+	assert.Equal(t, IsClosed, cb.State())
+	cb.Call()
+	assert.Equal(t, IsOpen, cb.State())
+}
+
+func TestIdleResetTimeoutForgetsTimeouts(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := createCircuitBreakerWithClock(createSleepyService(50*time.Millisecond), fallback, clock)
+	cb.Settings.Timeout = 10 * time.Millisecond
+	cb.Settings.FailureThreshold = 3
+	cb.Settings.IdleResetTimeout = time.Minute
+
+	cb.Call()
+	cb.Call()
+	assert.Equal(t, 2, cb.TimeoutCount())
+
+	clock.Advance(time.Minute)
+	assert.Equal(t, IsClosed, cb.State())
+	assert.Equal(t, 0, cb.CurrentFailureCount())
+	assert.Equal(t, 0, cb.TimeoutCount())
+}
+
+func TestIdleResetTimeoutOnCall(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := createCircuitBreakerWithClock(failingService, fallback, clock)
+	cb.Settings.FailureThreshold = 3
+	cb.Settings.IdleResetTimeout = time.Minute
+
+	cb.Call()
+	cb.Call()
+	clock.Advance(time.Minute)
+	cb.Call()
+	assert.Equal(t, 1, cb.CurrentFailureCount())
+	assert.Equal(t, IsClosed, cb.State())
+}
+
+func TestIdleResetTimeoutCountsFromLatestCall(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := createCircuitBreakerWithClock(failingService, fallback, clock)
+	cb.Settings.FailureThreshold = 4
+	cb.Settings.IdleResetTimeout = time.Minute
+
+	for i := 0; i < 3; i++ {
+		cb.Call()
+		clock.Advance(45 * time.Second)
+	}
+	assert.Equal(t, 3, cb.CurrentFailureCount())
+}
+
+func TestIdleResetTimeoutLeavesOpenCircuitAlone(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := createCircuitBreakerWithClock(failingService, fallback, clock)
+	cb.Settings.IdleResetTimeout = time.Millisecond
+
+	for i := 0; i < cb.Settings.FailureThreshold; i++ {
+		cb.Call()
+	}
+	clock.Advance(time.Second)
+	assert.Equal(t, IsOpen, cb.State())
+	assert.Equal(t, cb.Settings.FailureThreshold, cb.CurrentFailureCount())
+}
+
+func TestFailuresHaveTimestamps(t *testing.T) {
+	cb, _ := createCircuitBreaker(failingService, fallback)
+	assert.Empty(t, cb.Failures())
+
+	for i := 0; i < 5; i++ {
+		cb.Call()
+		time.Sleep(time.Millisecond)
+	}
+
+	failures := cb.Failures()
+	assert.Equal(t, cb.CurrentFailureCount(), len(failures))
+	for i, failure := range failures {
+		assert.Equal(t, cb.FailureRecord[i], failure.Err)
+		if i > 0 {
+			assert.True(t, failure.Time.After(failures[i-1].Time))
+		}
+	}
+	assert.Equal(t, cb.LastFailureTime, failures[len(failures)-1].Time)
+
+	// it is a copy
+	failures[0].Err = "whatever"
+	assert.NotEqual(t, "whatever", cb.Failures()[0].Err)
+
+	cb.Reset()
+	assert.Empty(t, cb.Failures())
+}
+
+func TestGroupedFailuresCollapseIdenticalOnes(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := createCircuitBreakerWithClock(failingService, fallback, clock)
+	cb.Settings.FailureThreshold = 1000
+	cb.Settings.MaxFailureRecords = 1000
+	assert.Empty(t, cb.GroupedFailures())
+
+	for i := 0; i < 500; i++ {
+		clock.Advance(time.Millisecond)
+		cb.Call()
+	}
+
+	groups := cb.GroupedFailures()
+	assert.Equal(t, 1, len(groups))
+	assert.Equal(t, 500, groups[0].Count)
+	assert.Contains(t, groups[0].Err, failingServiceError.Error())
+	assert.Equal(t, clock.Now(), groups[0].LastSeen)
+	assert.Equal(t, 500, len(cb.Failures()))
+}
+
+func TestGroupedFailuresOnlyCollapseInARow(t *testing.T) {
+	cb, _ := createCircuitBreaker(failingService, fallback)
+	cb.Settings.FailureThreshold = 10
+
+	cb.Call()
+	cb.Call()
+	cb.Settings.Service = notFoundService
+	cb.Call()
+	cb.Settings.Service = failingService
+	cb.Call()
+
+	var counts []int
+	for _, group := range cb.GroupedFailures() {
+		counts = append(counts, group.Count)
+	}
+	assert.Equal(t, []int{2, 1, 1}, counts)
+}
+
+func TestFailureRecordShouldBeBounded(t *testing.T) {
+	cb, _ := createCircuitBreaker(failingService, fallback)
+	cb.Settings.FailureThreshold = 10000
+	assert.Equal(t, DefaultMaxFailureRecords, cb.Settings.MaxFailureRecords)
+
+	for i := 0; i < 5000; i++ {
+		cb.Call()
+	}
+	assert.Equal(t, 5000, cb.CurrentFailureCount())
+	assert.Equal(t, cb.Settings.MaxFailureRecords, len(cb.FailureRecord))
+	assert.Equal(t, cb.Settings.MaxFailureRecords, len(cb.FailureTimes))
+	assert.Equal(t, cb.LastFailureTime, cb.FailureTimes[len(cb.FailureTimes)-1])
+}
+
+func TestTrimmedFailuresShouldGetStaleToo(t *testing.T) {
+	cb, _ := createCircuitBreaker(failingService, fallback)
+	cb.Settings.WindowDuration = 200 * time.Millisecond
+	cb.Settings.MaxFailureRecords = 2
+	cb.Settings.FailureThreshold = 4
+
+	for i := 0; i < 3; i++ {
+		cb.Call()
+	}
+	assert.Equal(t, 3, cb.CurrentFailureCount())
+	assert.Equal(t, 2, len(cb.FailureRecord))
+
+	time.Sleep(300 * time.Millisecond)
+	cb.Call()
+	assert.Equal(t, 1, cb.CurrentFailureCount())
+	assert.Equal(t, 1, len(cb.FailureRecord))
+	assert.Equal(t, IsClosed, cb.State())
+}
+
+func TestStaleFailuresShouldNotTripTheCircuit(t *testing.T) {
+	cb, _ := createCircuitBreaker(failingService, fallback)
+	cb.Settings.WindowDuration = 200 * time.Millisecond
+
+	cb.Call()
+	assert.Equal(t, 1, cb.CurrentFailureCount())
+	assert.Equal(t, IsClosed, cb.State())
+
+	// by now the first failure is out of the window
+	time.Sleep(300 * time.Millisecond)
+	cb.Call()
+	assert.Equal(t, 1, cb.CurrentFailureCount())
+	assert.Equal(t, 1, len(cb.FailureRecord))
+	assert.Equal(t, 1, len(cb.FailureTimes))
+	assert.Equal(t, IsClosed, cb.State())
+
+	// but two failures within the window are too much
+	cb.Call()
+	assert.Equal(t, 2, cb.CurrentFailureCount())
+	assert.Equal(t, IsOpen, cb.State())
+}
+
+func TestFailuresWithoutWindowShouldNeverGetStale(t *testing.T) {
+	cb, _ := createCircuitBreakerWithRetryTimePeriod(failingService, fallback, time.Second)
+
+	cb.Call()
+	time.Sleep(300 * time.Millisecond)
+	cb.Call()
+	assert.Equal(t, 2, cb.CurrentFailureCount())
+	assert.Equal(t, IsOpen, cb.State())
+}
+
+func callWithFailurePercentage(failures int) *CircuitBreaker {
+	calls := 0
+	service := func() (interface{}, error) {
+		calls++
+		if calls <= failures {
+			return nil, failingServiceError
+		}
+		return healthServiceContent, nil
+	}
+
+	cb, _ := createCircuitBreaker(service, fallback)
+	cb.Settings.ErrorPercentThreshold = 50
+	cb.Settings.RollingWindowSize = 100
+	for i := 0; i < cb.Settings.RollingWindowSize; i++ {
+		cb.Call()
+	}
+	return cb
+}
+
+func TestCircuitShouldStayClosedBelowErrorPercentThreshold(t *testing.T) {
+	cb := callWithFailurePercentage(49)
+	assert.Equal(t, IsClosed, cb.State())
+}
+
+func TestCircuitShouldStayClosedAtErrorPercentThreshold(t *testing.T) {
+	// right at it is still tolerated
+	cb := callWithFailurePercentage(50)
+	assert.Equal(t, IsClosed, cb.State())
+}
+
+func TestErrorPercentThresholdOutOfRangeIsRejected(t *testing.T) {
+	for _, threshold := range []int{-1, 100} {
+		cb, err := NewCircuitBreaker(CircuitSettings{Service: healthService, ErrorPercentThreshold: threshold, RollingWindowSize: 10})
+		assert.Nil(t, cb)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestCircuitShouldOpenAboveErrorPercentThreshold(t *testing.T) {
+	cb := callWithFailurePercentage(51)
+	assert.Equal(t, IsOpen, cb.State())
+
+	res, fallbacked, err := cb.Call()
+	assert.Contains(t, err.Error(), fallbackDueToOpenStateMessage)
+	assert.True(t, fallbacked)
+	assert.Equal(t, fallbackContent, res)
+}
+
+func TestCircuitShouldIgnoreErrorPercentUntilWindowIsFull(t *testing.T) {
+	cb, _ := createCircuitBreaker(failingService, fallback)
+	cb.Settings.ErrorPercentThreshold = 50
+	cb.Settings.RollingWindowSize = 10
+
+	for i := 1; i < cb.Settings.RollingWindowSize; i++ {
+		cb.Call()
+		assert.Equal(t, IsClosed, cb.State())
+	}
+	cb.Call()
+	assert.Equal(t, IsOpen, cb.State())
+}
+
+func TestCircuitShouldIgnoreErrorPercentBelowMinRequestVolume(t *testing.T) {
+	cb, _ := createCircuitBreaker(failingService, fallback)
+	cb.Settings.ErrorPercentThreshold = 50
+	cb.Settings.RollingWindowSize = 20
+	cb.Settings.MinRequestVolume = 10
+
+	for i := 0; i < 3; i++ {
+		cb.Call()
+	}
+	// 100% of failures but too few requests
+	assert.Equal(t, IsClosed, cb.State())
+
+	for i := 3; i < cb.Settings.MinRequestVolume; i++ {
+		cb.Call()
+	}
+	// now there is enough volume, though the window isn't full yet
+	assert.Equal(t, IsOpen, cb.State())
+}
+
+func createCircuitBreakerWithSlowCallRate(service Callable) *CircuitBreaker {
+	cb, _ := createCircuitBreaker(service, fallback)
+	cb.Settings.Timeout = 200 * time.Millisecond
+	cb.Settings.SlowCallThreshold = 50 * time.Millisecond
+	cb.Settings.SlowCallRateThreshold = 50
+	cb.Settings.RollingWindowSize = 4
+	return cb
+}
+
+func TestCircuitShouldOpenAboveSlowCallRateThreshold(t *testing.T) {
+	// just under the timeout, but way over the slow call threshold
+	cb := createCircuitBreakerWithSlowCallRate(createSleepyService(150 * time.Millisecond))
+
+	for i := 1; i < cb.Settings.RollingWindowSize; i++ {
+		res, fallbacked, err := cb.Call()
+		assert.Nil(t, err)
+		assert.False(t, fallbacked)
+		assert.Equal(t, healthServiceContent, res)
+		assert.Equal(t, IsClosed, cb.State())
+	}
+	cb.Call()
+	assert.Equal(t, IsOpen, cb.State())
+	assert.Equal(t, 0, cb.CurrentFailureCount())
+
+	res, fallbacked, err := cb.Call()
+	assert.Contains(t, err.Error(), fallbackDueToOpenStateMessage)
+	assert.True(t, fallbacked)
+	assert.Equal(t, fallbackContent, res)
+}
+
+func TestCircuitShouldStayClosedBelowSlowCallRateThreshold(t *testing.T) {
+	calls := 0
+	service := func() (interface{}, error) {
+		calls++
+		if calls == 1 {
+			time.Sleep(150 * time.Millisecond)
+		}
+		return healthServiceContent, nil
+	}
+	cb := createCircuitBreakerWithSlowCallRate(service)
+
+	for i := 0; i < cb.Settings.RollingWindowSize*2; i++ {
+		cb.Call()
+	}
+	assert.Equal(t, IsClosed, cb.State())
 }
 
-func TestServiceIsHealth(t *testing.T) {
-	cb, _ := createCircuitBreaker(healthService, fallback)
-	res, fallbacked, err := cb.Call()
-	assert.Nil(t, err)
-	assert.Equal(t, false, fallbacked)
-	assert.Equal(t, healthServiceContent, res)
+func TestCircuitShouldStayClosedAtSlowCallRateThreshold(t *testing.T) {
+	calls := 0
+	service := func() (interface{}, error) {
+		calls++
+		if calls <= 2 {
+			time.Sleep(150 * time.Millisecond)
+		}
+		return healthServiceContent, nil
+	}
+	cb := createCircuitBreakerWithSlowCallRate(service)
+
+	// half of them are slow, which is right at it
+	for i := 0; i < cb.Settings.RollingWindowSize; i++ {
+		cb.Call()
+	}
 	assert.Equal(t, IsClosed, cb.State())
 }
 
-func TestServiceIsSlow(t *testing.T) {
-	cb, _ := createCircuitBreaker(slowService, fallback)
+func TestCircuitShouldIgnoreSlowCallsWithoutThreshold(t *testing.T) {
+	cb := createCircuitBreakerWithSlowCallRate(createSleepyService(100 * time.Millisecond))
+	cb.Settings.SlowCallRateThreshold = 0
+
+	for i := 0; i < cb.Settings.RollingWindowSize; i++ {
+		cb.Call()
+	}
+	assert.Equal(t, IsClosed, cb.State())
+}
+
+func TestCircuitShouldOpenWhenManuallyTripped(t *testing.T) {
+	cb, _ := createCircuitBreaker(healthService, fallback)
+
+	tripCount := 0
+	cb.Settings.OnTrip = func() {
+		tripCount++
+	}
+
+	cb.Trip()
+	assert.Equal(t, IsOpen, cb.State())
+	assert.Equal(t, 1, tripCount)
+
+	// it is open already, so nothing changes
+	cb.Trip()
+	assert.Equal(t, 1, tripCount)
+
 	res, fallbacked, err := cb.Call()
 	assert.NotNil(t, err)
-	assert.Contains(t, err.Error(), fallbackDueToErrorMessage)
+	assert.Contains(t, err.Error(), fallbackDueToOpenStateMessage)
 	assert.True(t, fallbacked)
 	assert.Equal(t, fallbackContent, res)
+}
+
+func TestCircuitShouldCloseWhenManuallyReset(t *testing.T) {
+	cb, _ := createCircuitBreaker(failingService, fallback)
+
+	resetCount := 0
+	cb.Settings.OnReset = func() {
+		resetCount++
+	}
+
+	for i := 0; i < cb.Settings.FailureThreshold; i++ {
+		cb.Call()
+	}
+	assert.Equal(t, IsOpen, cb.State())
+
+	cb.Reset()
 	assert.Equal(t, IsClosed, cb.State())
+	assert.Equal(t, 0, cb.CurrentFailureCount())
+	assert.Equal(t, 0, len(cb.FailureRecord))
+	assert.Equal(t, 1, resetCount)
+
+	// it is closed already, so nothing changes
+	cb.Reset()
+	assert.Equal(t, 1, resetCount)
 }
 
-func TestServiceIsSlowButThereIsNoFallback(t *testing.T) {
-	cb, _ := createCircuitBreakerWithNoFallback(slowService)
+func TestCircuitShouldStayOpenWhileForced(t *testing.T) {
+	cb, _ := createCircuitBreakerWithRetryTimePeriod(healthService, fallback, 100*time.Millisecond)
+
+	cb.ForceOpen()
+	assert.Equal(t, IsOpen, cb.State())
+
+	// way past retry time period it is still open
+	time.Sleep(3 * cb.Settings.RetryTimePeriod)
+	assert.Equal(t, IsOpen, cb.State())
+
 	res, fallbacked, err := cb.Call()
-	assert.NotNil(t, err)
-	assert.Contains(t, err.Error(), serviceTimedOutMessage)
-	assert.Equal(t, false, fallbacked)
-	assert.Nil(t, res)
+	assert.Contains(t, err.Error(), fallbackDueToOpenStateMessage)
+	assert.True(t, fallbacked)
+	assert.Equal(t, fallbackContent, res)
+	assert.Equal(t, IsOpen, cb.State())
+
+	// back to normal, the service never really failed
+	cb.ClearForced()
+	assert.Equal(t, IsClosed, cb.State())
+
+	res, fallbacked, err = cb.Call()
+	assert.Nil(t, err)
+	assert.False(t, fallbacked)
+	assert.Equal(t, healthServiceContent, res)
 	assert.Equal(t, IsClosed, cb.State())
 }
 
-func TestCircuitShouldOpenWhenReachThreashold(t *testing.T) {
-	cb, _ := createCircuitBreaker(slowService, fallback)
+func TestRetryTimePeriodShouldBackOffAfterEachFailedChance(t *testing.T) {
+	cb, _ := createCircuitBreakerWithRetryTimePeriod(failingService, fallback, 100*time.Millisecond)
+	cb.Settings.BackoffMultiplier = 2
+	cb.Settings.MaxRetryTimePeriod = 300 * time.Millisecond
+
+	for i := 0; i < cb.Settings.FailureThreshold; i++ {
+		cb.Call()
+	}
+	assert.Equal(t, IsOpen, cb.State())
+	assert.Equal(t, 100*time.Millisecond, cb.retryTimePeriod())
+
+	expected := []time.Duration{200 * time.Millisecond, 300 * time.Millisecond, 300 * time.Millisecond}
+	for _, period := range expected {
+		// it waits for the current period, then misses its chance
+		time.Sleep(cb.retryTimePeriod())
+		assert.Equal(t, IsHalfOpen, cb.State())
+		cb.Call()
+		assert.Equal(t, IsOpen, cb.State())
+		assert.Equal(t, period, cb.retryTimePeriod())
+	}
+
+	// halfway through the backed off period it is still open
+	time.Sleep(150 * time.Millisecond)
+	assert.Equal(t, IsOpen, cb.State())
+	time.Sleep(150 * time.Millisecond)
+	assert.Equal(t, IsHalfOpen, cb.State())
+
+	// once it is closed, backoff starts over
+	cb.Settings.Service = healthService
+	cb.Call()
 	assert.Equal(t, IsClosed, cb.State())
+	assert.Equal(t, 100*time.Millisecond, cb.retryTimePeriod())
+}
 
-	cb.Settings.OnTrip = func() {
+func tripWithInitialRetryPeriod(clock Clock, multiplier float64) *CircuitBreaker {
+	cb, _ := NewCircuitBreaker(CircuitSettings{
+		Service:            failingService,
+		Fallback:           fallback,
+		RetryTimePeriod:    time.Second,
+		InitialRetryPeriod: 100 * time.Millisecond,
+		BackoffMultiplier:  multiplier,
+		MaxRetryTimePeriod: 500 * time.Millisecond,
+		Clock:              clock,
+	})
+	for i := 0; i < cb.Settings.FailureThreshold; i++ {
+		cb.Call()
+	}
+	return cb
+}
+
+func TestFirstOpenIntervalIsInitialRetryPeriod(t *testing.T) {
+	clock := newFakeClock()
+	cb := tripWithInitialRetryPeriod(clock, 2)
+	assert.Equal(t, IsOpen, cb.State())
+
+	clock.Advance(100 * time.Millisecond)
+	assert.Equal(t, IsOpen, cb.State())
+	clock.Advance(time.Millisecond)
+	assert.Equal(t, IsHalfOpen, cb.State())
+}
+
+func TestBackoffStartsFromInitialRetryPeriod(t *testing.T) {
+	clock := newFakeClock()
+	cb := tripWithInitialRetryPeriod(clock, 2)
+	assert.Equal(t, 100*time.Millisecond, cb.retryTimePeriod())
+
+	expected := []time.Duration{200 * time.Millisecond, 400 * time.Millisecond, 500 * time.Millisecond}
+	for _, period := range expected {
+		clock.Advance(cb.retryTimePeriod() + time.Millisecond)
+		assert.Equal(t, IsHalfOpen, cb.State())
+		cb.Call()
 		assert.Equal(t, IsOpen, cb.State())
+		assert.Equal(t, period, cb.retryTimePeriod())
 	}
-	cb.Settings.OnReset = func() {
-		assert.Fail(t, "Should not reset")
+}
+
+func TestInitialRetryPeriodIsCappedByMaxRetryTimePeriod(t *testing.T) {
+	clock := newFakeClock()
+	cb := tripWithInitialRetryPeriod(clock, 2)
+	cb.Settings.InitialRetryPeriod = time.Second
+	assert.Equal(t, 500*time.Millisecond, cb.retryTimePeriod())
+
+	clock.Advance(500*time.Millisecond + time.Millisecond)
+	assert.Equal(t, IsHalfOpen, cb.State())
+	cb.Call()
+	assert.Equal(t, 500*time.Millisecond, cb.retryTimePeriod())
+}
+
+func TestInitialRetryPeriodWithoutBackoff(t *testing.T) {
+	clock := newFakeClock()
+	cb := tripWithInitialRetryPeriod(clock, 0)
+	assert.Equal(t, 100*time.Millisecond, cb.retryTimePeriod())
+
+	// it is only the first time that is any different
+	clock.Advance(101 * time.Millisecond)
+	cb.Call()
+	assert.Equal(t, IsOpen, cb.State())
+	assert.Equal(t, time.Second, cb.retryTimePeriod())
+}
+
+func TestInitialRetryPeriodIsJittered(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := NewCircuitBreaker(CircuitSettings{
+		Service:            failingService,
+		Fallback:           fallback,
+		InitialRetryPeriod: 100 * time.Millisecond,
+		RetryJitter:        10 * time.Millisecond,
+		Clock:              clock,
+	})
+	for i := 0; i < cb.Settings.FailureThreshold; i++ {
+		cb.Call()
 	}
+	assert.InDelta(t, float64(100*time.Millisecond), float64(cb.retryTimePeriod()), float64(10*time.Millisecond))
+}
+
+func TestNegativeInitialRetryPeriodIsRejected(t *testing.T) {
+	cb, err := NewCircuitBreaker(CircuitSettings{Service: healthService, InitialRetryPeriod: -time.Second})
+	assert.Nil(t, cb)
+	assert.NotNil(t, err)
+}
 
+func createSubMillisecondCircuitBreaker(clock Clock, resolution time.Duration) *CircuitBreaker {
+	cb, _ := NewCircuitBreaker(CircuitSettings{
+		Service:         failingService,
+		Fallback:        fallback,
+		RetryTimePeriod: 500 * time.Microsecond,
+		ClockResolution: resolution,
+		Clock:           clock,
+	})
 	for i := 0; i < cb.Settings.FailureThreshold; i++ {
-		assert.Equal(t, IsClosed, cb.State())
-		//
-		res, fallbacked, err := cb.Call()
-		assert.NotNil(t, err)
-		assert.Contains(t, err.Error(), fallbackDueToErrorMessage)
-		assert.True(t, fallbacked)
-		assert.Contains(t, fallbackContent, res)
+		cb.Call()
 	}
+	return cb
+}
+
+func TestSubMillisecondRetryTimePeriod(t *testing.T) {
+	clock := newFakeClock()
+	cb := createSubMillisecondCircuitBreaker(clock, time.Microsecond)
+	assert.Equal(t, IsOpen, cb.State())
+
+	clock.Advance(500 * time.Microsecond)
 	assert.Equal(t, IsOpen, cb.State())
+	clock.Advance(time.Microsecond)
+	assert.Equal(t, IsHalfOpen, cb.State())
 }
 
-func TestCircuitShouldHalfOpenAfterRetryTimePeriod(t *testing.T) {
-	cb, _ := createCircuitBreaker(slowService, fallback)
-	assert.Equal(t, IsClosed, cb.State())
+func TestRetryTimePeriodBelowClockResolution(t *testing.T) {
+	clock := newFakeClock()
+	cb := createSubMillisecondCircuitBreaker(clock, 0)
+	assert.Equal(t, DefaultClockResolution, cb.Settings.ClockResolution)
+
+	// it is taken as long as the resolution
+	clock.Advance(900 * time.Microsecond)
+	assert.Equal(t, IsOpen, cb.State())
+	clock.Advance(200 * time.Microsecond)
+	assert.Equal(t, IsHalfOpen, cb.State())
+}
+
+func TestJitterNeverMakesItHalfOpenRightAway(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := NewCircuitBreaker(CircuitSettings{
+		Service:         failingService,
+		Fallback:        fallback,
+		RetryTimePeriod: time.Millisecond,
+		RetryJitter:     time.Second,
+		Clock:           clock,
+		RandSource:      rand.NewSource(1),
+	})
+
+	// whichever way the jitter goes, even way below zero
+	for i := 0; i < 20; i++ {
+		cb.Trip()
+		assert.GreaterOrEqual(t, cb.retryTimePeriod(), cb.Settings.ClockResolution)
+		assert.Equal(t, IsOpen, cb.State())
+		cb.Reset()
+	}
+}
+
+func TestRetryTimePeriodBelowClockResolutionIsWarned(t *testing.T) {
+	handler := &recordingHandler{}
+	NewCircuitBreaker(CircuitSettings{
+		Service:         healthService,
+		RetryTimePeriod: 100 * time.Microsecond,
+		Logger:          slog.New(handler),
+	})
+
+	assert.Equal(t, 1, len(handler.records))
+	assert.Equal(t, slog.LevelWarn, handler.records[0].Level)
+	assert.Equal(t, 100*time.Microsecond, attrsOf(handler.records[0])["retry_time_period"].Duration())
+
+	handler = &recordingHandler{}
+	NewCircuitBreaker(CircuitSettings{
+		Service: healthService,
+		Logger:  slog.New(handler),
+	})
+	assert.Empty(t, handler.records)
+}
+
+func createJitteryCircuitBreaker(seed int64) *CircuitBreaker {
+	cb, _ := NewCircuitBreaker(CircuitSettings{
+		Service:         failingService,
+		Fallback:        fallback,
+		RetryTimePeriod: 500 * time.Millisecond,
+		RetryJitter:     100 * time.Millisecond,
+		RandSource:      rand.NewSource(seed),
+	})
 
 	for i := 0; i < cb.Settings.FailureThreshold; i++ {
-		// still closed while inside failure threashold
-		assert.Equal(t, IsClosed, cb.State())
-		//
-		res, fallbacked, err := cb.Call()
-		assert.NotNil(t, err)
-		assert.Contains(t, err.Error(), fallbackDueToErrorMessage)
-		assert.True(t, fallbacked)
-		assert.Contains(t, fallbackContent, res)
+		cb.Call()
 	}
-	// should trip after reach failure threashold
-	assert.Equal(t, IsOpen, cb.State())
+	return cb
+}
 
-	// wait something to benefit from a half-open state due to retry time period
-	time.Sleep(2 * time.Second)
+func TestRetryTimePeriodShouldBeJittered(t *testing.T) {
+	periods := map[time.Duration]bool{}
+	for seed := int64(1); seed <= 5; seed++ {
+		cb := createJitteryCircuitBreaker(seed)
+		period := cb.retryTimePeriod()
+		assert.GreaterOrEqual(t, period, 400*time.Millisecond)
+		assert.LessOrEqual(t, period, 600*time.Millisecond)
+		periods[period] = true
+
+		// same seed, same jitter
+		assert.Equal(t, period, createJitteryCircuitBreaker(seed).retryTimePeriod())
+	}
+	assert.Greater(t, len(periods), 1)
+}
+
+func TestHalfOpenTransitionShouldBeJittered(t *testing.T) {
+	early := createJitteryCircuitBreaker(1)
+	late := createJitteryCircuitBreaker(1)
+	early.retryJitter = -100 * time.Millisecond
+	late.retryJitter = 100 * time.Millisecond
+
+	time.Sleep(early.retryTimePeriod())
+	assert.Equal(t, IsHalfOpen, early.State())
+	assert.Equal(t, IsOpen, late.State())
+
+	time.Sleep(late.retryTimePeriod() - early.retryTimePeriod())
+	assert.Equal(t, IsHalfOpen, late.State())
+}
+
+func TestHalfOpenShouldLimitCallsToService(t *testing.T) {
+	var hits int32
+	service := func() (interface{}, error) {
+		atomic.AddInt32(&hits, 1)
+		time.Sleep(200 * time.Millisecond)
+		return healthServiceContent, nil
+	}
+	cb, _ := createCircuitBreakerWithRetryTimePeriod(service, fallback, 100*time.Millisecond)
+	cb.Settings.HalfOpenMaxCalls = 2
+
+	cb.Trip()
+	time.Sleep(cb.Settings.RetryTimePeriod)
 	assert.Equal(t, IsHalfOpen, cb.State())
 
+	var fallbacks int32
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			_, fallbacked, _ := cb.Call()
+			if fallbacked {
+				atomic.AddInt32(&fallbacks, 1)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	assert.Equal(t, int32(cb.Settings.HalfOpenMaxCalls), atomic.LoadInt32(&hits))
+	assert.Equal(t, int32(50-cb.Settings.HalfOpenMaxCalls), atomic.LoadInt32(&fallbacks))
+	assert.Equal(t, IsClosed, cb.State())
+}
+
+func TestBulkheadShouldRejectCallsOverTheLimit(t *testing.T) {
+	var hits int32
+	release := make(chan struct{})
+	cb, _ := NewCircuitBreaker(CircuitSettings{
+		Service:            createBlockedService(&hits, release),
+		Fallback:           fallback,
+		MaxConcurrentCalls: 3,
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < cb.Settings.MaxConcurrentCalls; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, fallbacked, err := cb.Call()
+			assert.Nil(t, err)
+			assert.False(t, fallbacked)
+		}()
+	}
+	for atomic.LoadInt32(&hits) < int32(cb.Settings.MaxConcurrentCalls) {
+		time.Sleep(time.Millisecond)
+	}
+
+	// these ones are too many
 	res, fallbacked, err := cb.Call()
-	assert.NotNil(t, err)
-	assert.Contains(t, err.Error(), fallbackDueToErrorMessage)
+	assert.Contains(t, err.Error(), fallbackDueToFullBulkheadMessage)
 	assert.True(t, fallbacked)
-	assert.Contains(t, fallbackContent, res)
-	assert.Equal(t, IsOpen, cb.State())
+	assert.Equal(t, fallbackContent, res)
+
+	cb.Settings.Fallback = nil
+	res, fallbacked, err = cb.Call()
+	assert.Contains(t, err.Error(), bulkheadIsFullMessage)
+	assert.False(t, fallbacked)
+	assert.Nil(t, res)
+
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(cb.Settings.MaxConcurrentCalls), atomic.LoadInt32(&hits))
+	assert.Equal(t, 0, cb.CurrentFailureCount())
+	assert.Equal(t, IsClosed, cb.State())
+
+	// there is room again
+	res, fallbacked, err = cb.Call()
+	assert.Nil(t, err)
+	assert.False(t, fallbacked)
+	assert.Equal(t, healthServiceContent, res)
+}
+
+func TestMinCallIntervalSpacesServiceCalls(t *testing.T) {
+	clock := newFakeClock()
+	hits := 0
+	cb, _ := createCircuitBreakerWithClock(func() (interface{}, error) {
+		hits++
+		return healthServiceContent, nil
+	}, fallback, clock)
+	cb.Settings.MinCallInterval = 100 * time.Millisecond
+
+	reached := 0
+	for i := 0; i < 10; i++ {
+		// one call every 30 milliseconds, so every fourth one gets through
+		res, fallbacked, err := cb.Call()
+		if !fallbacked {
+			reached++
+			assert.Equal(t, healthServiceContent, res)
+			assert.Nil(t, err)
+		} else {
+			assert.Equal(t, fallbackContent, res)
+			assert.True(t, errors.Is(err, ErrCallTooSoon))
+			assert.Contains(t, err.Error(), "Service was fallbacked due to call rate")
+		}
+		clock.Advance(30 * time.Millisecond)
+	}
+	assert.Equal(t, 3, hits)
+	assert.Equal(t, 3, reached)
+	// calls that were too soon say nothing about the service health
+	assert.Equal(t, 0, cb.CurrentFailureCount())
+	assert.Equal(t, IsClosed, cb.State())
+}
+
+func TestMinCallIntervalWithRapidCalls(t *testing.T) {
+	clock := newFakeClock()
+	hits := 0
+	cb, _ := createCircuitBreakerWithClock(func() (interface{}, error) {
+		hits++
+		return healthServiceContent, nil
+	}, fallback, clock)
+	cb.Settings.MinCallInterval = time.Second
+
+	for i := 0; i < 5; i++ {
+		cb.Call()
+	}
+	assert.Equal(t, 1, hits)
+
+	clock.Advance(time.Second)
+	_, fallbacked, reason, err := cb.CallWithReason()
+	assert.False(t, fallbacked)
+	assert.Nil(t, err)
+	_, fallbacked, reason, err = cb.CallWithReason()
+	assert.True(t, fallbacked)
+	assert.Equal(t, ReasonCallTooSoon, reason)
+	assert.Equal(t, 2, hits)
+
+	cb.Settings.Fallback = nil
+	_, fallbacked, _, err = cb.CallWithReason()
+	assert.False(t, fallbacked)
+	assert.True(t, errors.Is(err, ErrCallTooSoon))
+	assert.True(t, errors.Is(err, ErrNoFallback))
 }
 
 func TestServiceIsAlwaysSlow(t *testing.T) {
-	cb, _ := createCircuitBreaker(slowService, fallback)
+	clock := newFakeClock()
+	cb, _ := createCircuitBreakerWithClock(slowService, fallback, clock)
 	assert.Equal(t, IsClosed, cb.State())
 
 	for i := 0; i < cb.Settings.FailureThreshold; i++ {
@@ -128,7 +3011,7 @@ func TestServiceIsAlwaysSlow(t *testing.T) {
 	}
 
 	// wait something to benefit from a half-open state due to retry time period
-	time.Sleep(2 * time.Second)
+	clock.Advance(cb.Settings.RetryTimePeriod + time.Nanosecond)
 	assert.Equal(t, IsHalfOpen, cb.State())
 
 	res, fallbacked, err := cb.Call()
@@ -147,10 +3030,11 @@ func TestServiceIsAlwaysSlow(t *testing.T) {
 }
 
 func TestServiceIsIntermittentlySlow(t *testing.T) {
-	cb, _ := createCircuitBreaker(countdownToHealthService, fallback)
+	clock := newFakeClock()
+	cb, _ := createCircuitBreakerWithClock(countdownToHealthService, fallback, clock)
 	assert.Equal(t, IsClosed, cb.State())
 
-	for i := countdownToHealth; i > 0; i-- {
+	for i := atomic.LoadInt32(&countdownToHealth); i > 0; i-- {
 		res, fallbacked, err := cb.Call()
 		assert.NotNil(t, err)
 		assert.True(t, fallbacked)
@@ -158,10 +3042,10 @@ func TestServiceIsIntermittentlySlow(t *testing.T) {
 	}
 	// should trip after reach failure threashold
 	assert.Equal(t, IsOpen, cb.State())
-	assert.Equal(t, 1, countdownToHealth)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&countdownToHealth))
 
 	// wait something to benefit from a half-open state due to retry time period
-	time.Sleep(2 * time.Second)
+	clock.Advance(cb.Settings.RetryTimePeriod + time.Nanosecond)
 	assert.Equal(t, IsHalfOpen, cb.State())
 
 	// will fail again
@@ -170,10 +3054,10 @@ func TestServiceIsIntermittentlySlow(t *testing.T) {
 	assert.True(t, fallbacked)
 	assert.Contains(t, fallbackContent, res)
 	assert.Equal(t, IsOpen, cb.State())
-	assert.Equal(t, 0, countdownToHealth)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&countdownToHealth))
 
 	// wait a little bit more
-	time.Sleep(2 * time.Second)
+	clock.Advance(cb.Settings.RetryTimePeriod + time.Nanosecond)
 	assert.Equal(t, IsHalfOpen, cb.State())
 
 	// countdonw is over and service should be health now
@@ -189,3 +3073,125 @@ func TestServiceIsIntermittentlySlow(t *testing.T) {
 		assert.Equal(t, IsClosed, cb.State())
 	}
 }
+
+func TestConcurrentCallsAreSafe(t *testing.T) {
+	cb, _ := createCircuitBreaker(failingService, fallback)
+
+	cb.Settings.OnTrip = func() {
+		// nobody else may record a failure while we are looking at it
+		failureCount := cb.CurrentFailureCount()
+		assert.GreaterOrEqual(t, failureCount, cb.Settings.FailureThreshold)
+		assert.Equal(t, failureCount, len(cb.FailureRecord))
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, fallbacked, err := cb.Call()
+			assert.NotNil(t, err)
+			assert.True(t, fallbacked)
+			assert.Equal(t, fallbackContent, res)
+		}()
+	}
+	wg.Wait()
+
+	assert.GreaterOrEqual(t, cb.CurrentFailureCount(), cb.Settings.FailureThreshold)
+	assert.Equal(t, cb.CurrentFailureCount(), len(cb.FailureRecord))
+}
+
+func TestCurrentFailureCountWhileCalling(t *testing.T) {
+	cb, _ := createCircuitBreaker(failingService, fallback)
+	cb.Settings.FailureThreshold = 1000
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			cb.Call()
+		}()
+		go func() {
+			defer wg.Done()
+			// meant to be run with -race
+			failureCount := cb.CurrentFailureCount()
+			assert.GreaterOrEqual(t, failureCount, 0)
+			assert.LessOrEqual(t, failureCount, 50)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 50, cb.CurrentFailureCount())
+}
+
+func TestSynchronousCallSpawnsNoGoroutine(t *testing.T) {
+	cb, _ := createSynchronousCircuitBreaker(healthService, fallback)
+
+	before := runtime.NumGoroutine()
+	var during int
+	cb.Settings.Service = func() (interface{}, error) {
+		during = runtime.NumGoroutine()
+		return healthService()
+	}
+
+	res, fallbacked, err := cb.Call()
+	assert.Nil(t, err)
+	assert.False(t, fallbacked)
+	assert.Equal(t, healthServiceContent, res)
+	assert.LessOrEqual(t, during, before)
+}
+
+func TestSynchronousCallHasNoTimeoutUnlessConfigured(t *testing.T) {
+	cb, _ := createSynchronousCircuitBreaker(createSleepyService(20*time.Millisecond), fallback)
+
+	res, fallbacked, err := cb.Call()
+	assert.Nil(t, err)
+	assert.False(t, fallbacked)
+	assert.Equal(t, healthServiceContent, res)
+}
+
+func TestSynchronousCallStillTimesOutWithTimeout(t *testing.T) {
+	cb, _ := createSynchronousCircuitBreaker(createSleepyService(200*time.Millisecond), fallback)
+	cb.Settings.Timeout = 10 * time.Millisecond
+
+	start := time.Now()
+	res, fallbacked, err := cb.Call()
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+	assert.True(t, fallbacked)
+	assert.Equal(t, fallbackContent, res)
+	assert.ErrorIs(t, err, ErrServiceTimeout)
+}
+
+func TestSynchronousCallStillTimesOutWithCallTimeout(t *testing.T) {
+	cb, _ := createSynchronousCircuitBreaker(createSleepyService(200*time.Millisecond), fallback)
+
+	start := time.Now()
+	res, fallbacked, err := cb.CallWithTimeout(10 * time.Millisecond)
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+	assert.True(t, fallbacked)
+	assert.Equal(t, fallbackContent, res)
+	assert.ErrorIs(t, err, ErrServiceTimeout)
+}
+
+func TestSynchronousCallStillStopsWhenContextIsDone(t *testing.T) {
+	cb, _ := createSynchronousCircuitBreaker(createSleepyService(200*time.Millisecond), fallback)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, fallbacked, err := cb.CallContext(ctx)
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+	assert.True(t, fallbacked)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestSynchronousCallRecoversFromPanic(t *testing.T) {
+	cb, _ := createSynchronousCircuitBreaker(panickingService, fallback)
+
+	res, fallbacked, err := cb.Call()
+	assert.True(t, fallbacked)
+	assert.Equal(t, fallbackContent, res)
+	assert.Contains(t, err.Error(), servicePanickedMessage)
+}
@@ -0,0 +1,33 @@
+package main
+
+// Close stops whatever the circuit breaker keeps running in the background,
+// e.g. held back callbacks, and closes the channels given by Subscribe. From
+// then on calls get ErrClosed, without calling the service or the fallback.
+func (cb *CircuitBreaker) Close() error {
+	cb.eventMutex.Lock()
+	defer cb.eventMutex.Unlock()
+
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	if cb.shutdown {
+		return nil
+	}
+	cb.shutdown = true
+
+	if cb.callbackTimer != nil {
+		cb.callbackTimer.Stop()
+		cb.callbackTimer = nil
+	}
+	for _, subscriber := range cb.subscribers {
+		close(subscriber)
+	}
+	cb.subscribers = nil
+	return nil
+}
+
+func (cb *CircuitBreaker) isShutdown() bool {
+	cb.mutex.RLock()
+	defer cb.mutex.RUnlock()
+	return cb.shutdown
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCallAfterCloseFails(t *testing.T) {
+	hits := 0
+	cb, _ := createCircuitBreaker(func() (interface{}, error) {
+		hits++
+		return healthServiceContent, nil
+	}, fallback)
+
+	assert.Nil(t, cb.Close())
+
+	res, fallbacked, err := cb.Call()
+	assert.Nil(t, res)
+	assert.False(t, fallbacked)
+	assert.True(t, errors.Is(err, ErrClosed))
+	assert.Equal(t, 0, hits)
+	assert.False(t, cb.Allow())
+
+	// once is enough, but twice does no harm
+	assert.Nil(t, cb.Close())
+}
+
+func TestCloseClosesSubscriptions(t *testing.T) {
+	cb, _ := createCircuitBreaker(healthService, fallback)
+	subscription := cb.Subscribe()
+
+	cb.Close()
+	_, ok := <-subscription
+	assert.False(t, ok)
+
+	_, ok = <-cb.Subscribe()
+	assert.False(t, ok)
+}
+
+func TestCloseLeaksNoGoroutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	cb, _ := createCircuitBreaker(healthService, fallback)
+	cb.Settings.CallbackMinInterval = time.Hour
+	callbacks := 0
+	cb.Settings.OnStateChange = func() {
+		callbacks++
+	}
+
+	done := make(chan struct{})
+	subscription := cb.Subscribe()
+	go func() {
+		defer close(done)
+		for range subscription {
+		}
+	}()
+
+	cb.Trip()
+	cb.Reset()
+	cb.Call()
+	cb.Close()
+	<-done
+
+	time.Sleep(10 * time.Millisecond)
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before)
+	// the held back callback is gone along with the timer
+	assert.Equal(t, 1, callbacks)
+}
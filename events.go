@@ -0,0 +1,49 @@
+package main
+
+// How many transitions a subscriber may fall behind before missing some
+const subscriptionBuffer = 16
+
+// Subscribe gives a channel which gets every state transition from now on,
+// until Unsubscribe. A subscriber that falls too far behind misses some of
+// them rather than holding the circuit up.
+func (cb *CircuitBreaker) Subscribe() <-chan StateTransition {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	subscription := make(chan StateTransition, subscriptionBuffer)
+	if cb.shutdown {
+		// There is nothing more to come
+		close(subscription)
+		return subscription
+	}
+	cb.subscribers = append(cb.subscribers, subscription)
+	return subscription
+}
+
+// Unsubscribe stops the channel given by Subscribe from getting transitions,
+// and closes it
+func (cb *CircuitBreaker) Unsubscribe(subscription <-chan StateTransition) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	for i, subscriber := range cb.subscribers {
+		if subscriber == subscription {
+			cb.subscribers = append(cb.subscribers[:i], cb.subscribers[i+1:]...)
+			close(subscriber)
+			return
+		}
+	}
+}
+
+func (cb *CircuitBreaker) publish(transition StateTransition) {
+	cb.mutex.RLock()
+	defer cb.mutex.RUnlock()
+
+	for _, subscriber := range cb.subscribers {
+		select {
+		case subscriber <- transition:
+		default:
+			// Too slow to keep up, so this one is gone for it
+		}
+	}
+}
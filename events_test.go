@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscribersGetTrip(t *testing.T) {
+	cb, _ := createCircuitBreaker(failingService, fallback)
+	first := cb.Subscribe()
+	second := cb.Subscribe()
+
+	for i := 0; i < cb.Settings.FailureThreshold; i++ {
+		cb.Call()
+	}
+
+	for _, subscription := range []<-chan StateTransition{first, second} {
+		select {
+		case transition := <-subscription:
+			assert.Equal(t, IsClosed, transition.From)
+			assert.Equal(t, IsOpen, transition.To)
+		default:
+			t.Fatal("subscriber didn't get the trip")
+		}
+	}
+}
+
+func TestSlowSubscriberDoesNotHoldCircuitUp(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := createCircuitBreakerWithClock(healthService, fallback, clock)
+	slow := cb.Subscribe()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < subscriptionBuffer*2; i++ {
+			cb.Trip()
+			cb.Reset()
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("circuit is held up by a slow subscriber")
+	}
+
+	assert.Equal(t, subscriptionBuffer, len(slow))
+	// the oldest ones are kept, the rest were missed
+	transition := <-slow
+	assert.Equal(t, IsOpen, transition.To)
+}
+
+func TestUnsubscribeClosesChannel(t *testing.T) {
+	cb, _ := createCircuitBreaker(healthService, fallback)
+	subscription := cb.Subscribe()
+	other := cb.Subscribe()
+
+	cb.Unsubscribe(subscription)
+	_, ok := <-subscription
+	assert.False(t, ok)
+
+	cb.Trip()
+	assert.Equal(t, 1, len(other))
+
+	// nothing happens for an unknown one
+	cb.Unsubscribe(make(chan StateTransition))
+}
@@ -1,12 +1,18 @@
 package main
 
 import (
+	"errors"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var serviceTimedOutMessage = "Service timed out"
 var fallbackDueToOpenStateMessage = "Service was fallbacked due to open state"
 var fallbackDueToErrorMessage = "Service was fallbacked due to error"
+var circuitIsOpenMessage = "Circuit is open"
+var fallbackDueToFullBulkheadMessage = "Service was fallbacked due to full bulkhead"
+var bulkheadIsFullMessage = "Bulkhead is full"
 
 func createCircuitBreaker(service Callable, fallback Callable) (*CircuitBreaker, error) {
 	return NewCircuitBreaker(CircuitSettings{
@@ -18,6 +24,16 @@ func createCircuitBreaker(service Callable, fallback Callable) (*CircuitBreaker,
 	})
 }
 
+func createCircuitBreakerWithRetryTimePeriod(service Callable, fallback Callable, retryTimePeriod time.Duration) (*CircuitBreaker, error) {
+	return NewCircuitBreaker(CircuitSettings{
+		Service:          service,
+		Fallback:         fallback,
+		Timeout:          DefautTimeout,
+		RetryTimePeriod:  retryTimePeriod,
+		FailureThreshold: DefautlFailureThreshold,
+	})
+}
+
 func createCircuitBreakerWithNoFallback(service Callable) (*CircuitBreaker, error) {
 	return createCircuitBreaker(service, nil)
 }
@@ -26,11 +42,89 @@ func createCircuitBreakerWithNoService() (*CircuitBreaker, error) {
 	return createCircuitBreaker(nil, nil)
 }
 
+func createSynchronousCircuitBreaker(service Callable, fallback Callable) (*CircuitBreaker, error) {
+	cb, err := createCircuitBreaker(service, fallback)
+	if err == nil {
+		// With no timeout, calls don't need a goroutine of ours
+		cb.synchronous = true
+		cb.Settings.Timeout = 0
+	}
+	return cb, err
+}
+
+func createCircuitBreakerWithClock(service Callable, fallback Callable, clock Clock) (*CircuitBreaker, error) {
+	return NewCircuitBreaker(CircuitSettings{
+		Service:          service,
+		Fallback:         fallback,
+		Timeout:          DefautTimeout,
+		RetryTimePeriod:  DefaultRetryTimePeriod,
+		FailureThreshold: DefautlFailureThreshold,
+		Clock:            clock,
+	})
+}
+
+// Clock
+type fakeClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// State store
+type fakeStore struct {
+	mutex     sync.Mutex
+	snapshots []StateSnapshot
+	loads     int
+	err       error
+}
+
+func (s *fakeStore) Load() (StateSnapshot, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.loads++
+	if s.err != nil {
+		return StateSnapshot{}, s.err
+	}
+	if len(s.snapshots) == 0 {
+		return StateSnapshot{}, nil
+	}
+	return s.snapshots[len(s.snapshots)-1], nil
+}
+
+func (s *fakeStore) Save(snapshot StateSnapshot) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.snapshots = append(s.snapshots, snapshot)
+	return nil
+}
+
 // Fallback
 var fallbackContent = "Relying on a fallback cached content"
 
-func fallback() (interface{}, error) {
-	return fallbackContent, nil
+var fallback = StaticFallback(fallbackContent)
+
+var fallbackPanickedMessage = "Fallback panicked"
+
+func panickingFallback() (interface{}, error) {
+	panic("Fallback is out of its mind")
 }
 
 // Health
@@ -40,21 +134,91 @@ func healthService() (interface{}, error) {
 	return healthServiceContent, nil
 }
 
+// Failing
+var failingServiceError = errors.New("Service is failing")
+
+func failingService() (interface{}, error) {
+	return nil, failingServiceError
+}
+
+// Not found
+var errNotFound = errors.New("Not found")
+
+func notFoundService() (interface{}, error) {
+	return nil, errNotFound
+}
+
+func ignoreNotFound(err error) bool {
+	return err != errNotFound
+}
+
+// Nil
+var serviceRespondIsNilMessage = "Service respond is nil"
+
+func nilService() (interface{}, error) {
+	return nil, nil
+}
+
+// Responds an error status
+var serviceRespondIsNotSuccessMessage = "Service respond is not a success"
+
+type statusResponse struct {
+	Status int
+}
+
+func errorStatusService() (interface{}, error) {
+	return statusResponse{500}, nil
+}
+
+func isStatusSuccess(content interface{}, err error) bool {
+	res, ok := content.(statusResponse)
+	return err == nil && ok && res.Status < 500
+}
+
+// Panicking
+var servicePanickedMessage = "Service panicked"
+
+func panickingService() (interface{}, error) {
+	panic("Service is out of its mind")
+}
+
 // Slow
 func slowService() (interface{}, error) {
 	time.Sleep(5 * time.Minute)
 	return "This is a veeery slooow response", nil
 }
 
+// Sleepy
+func createSleepyService(sleep time.Duration) Callable {
+	return func() (interface{}, error) {
+		time.Sleep(sleep)
+		return healthServiceContent, nil
+	}
+}
+
+// Blocked until released
+func createBlockedService(hits *int32, release chan struct{}) Callable {
+	return func() (interface{}, error) {
+		atomic.AddInt32(hits, 1)
+		<-release
+		return healthServiceContent, nil
+	}
+}
+
 // Slow then fast
-var countdownToHealth = 3
+// It is touched by timed out calls still on their way, hence atomic
+var countdownToHealth int32 = 3
 var countdownToHealthContent = "This is a health fast response"
 
 func countdownToHealthService() (interface{}, error) {
-	if countdownToHealth > 0 {
-		countdownToHealth = countdownToHealth - 1
-		time.Sleep(1 * time.Minute)
-		return "This is a slow response", nil
+	for {
+		left := atomic.LoadInt32(&countdownToHealth)
+		if left <= 0 {
+			return countdownToHealthContent, nil
+		}
+		if atomic.CompareAndSwapInt32(&countdownToHealth, left, left-1) {
+			time.Sleep(1 * time.Minute)
+			return "This is a slow response", nil
+		}
 	}
-	return countdownToHealthContent, nil
 }
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"sync"
+)
+
+// Group keeps an eye on circuit breakers which depend on the same thing, e.g.
+// endpoints of the same backend, so that when enough of them are open the rest
+// open too, rather than each one finding out the hard way
+type Group struct {
+	// Tells whether the states of the members are bad enough for all of them
+	// to be opened, e.g. MajorityOpen
+	OpenAllWhen func(states []CircuitState) bool
+
+	members       []*CircuitBreaker
+	subscriptions []<-chan StateTransition
+	waitGroup     sync.WaitGroup
+	closeOnce     sync.Once
+}
+
+// NewGroup builds a group of those members, which from now on are opened all
+// together whenever openAllWhen says so
+func NewGroup(openAllWhen func(states []CircuitState) bool, members ...*CircuitBreaker) *Group {
+	g := &Group{
+		OpenAllWhen: openAllWhen,
+		members:     members,
+	}
+	for _, cb := range members {
+		subscription := cb.Subscribe()
+		g.subscriptions = append(g.subscriptions, subscription)
+		g.waitGroup.Add(1)
+		go g.watch(subscription)
+	}
+	return g
+}
+
+// MajorityOpen is an OpenAllWhen policy for when more than half are open
+func MajorityOpen(states []CircuitState) bool {
+	open := 0
+	for _, state := range states {
+		if state == IsOpen {
+			open++
+		}
+	}
+	return open*2 > len(states)
+}
+
+// Members gives the circuit breakers in the group
+func (g *Group) Members() []*CircuitBreaker {
+	members := make([]*CircuitBreaker, len(g.members))
+	copy(members, g.members)
+	return members
+}
+
+// States gives the state of every member, in the same order as Members
+func (g *Group) States() []CircuitState {
+	states := make([]CircuitState, len(g.members))
+	for i, cb := range g.members {
+		states[i] = cb.State()
+	}
+	return states
+}
+
+// Close stops the group from watching its members, which are left as they are
+func (g *Group) Close() error {
+	g.closeOnce.Do(func() {
+		for i, cb := range g.members {
+			cb.Unsubscribe(g.subscriptions[i])
+		}
+	})
+	g.waitGroup.Wait()
+	return nil
+}
+
+func (g *Group) watch(subscription <-chan StateTransition) {
+	defer g.waitGroup.Done()
+
+	for transition := range subscription {
+		if transition.To == IsOpen {
+			g.mayOpenAll()
+		}
+	}
+}
+
+func (g *Group) mayOpenAll() {
+	if g.OpenAllWhen == nil || !g.OpenAllWhen(g.States()) {
+		return
+	}
+	for _, cb := range g.members {
+		if cb.State() != IsOpen {
+			// It is only a matter of time, so there is no point in waiting
+			cb.Trip()
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func createGroupMembers(n int) []*CircuitBreaker {
+	members := make([]*CircuitBreaker, n)
+	for i := range members {
+		members[i], _ = createCircuitBreaker(healthService, fallback)
+	}
+	return members
+}
+
+func TestGroupCascadesOpenOnMajority(t *testing.T) {
+	members := createGroupMembers(3)
+	g := NewGroup(MajorityOpen, members...)
+	defer g.Close()
+
+	members[0].Trip()
+	members[1].Trip()
+
+	assert.Eventually(t, func() bool {
+		return members[2].State() == IsOpen
+	}, time.Second, time.Millisecond)
+}
+
+func TestGroupDoesNotCascadeOnMinority(t *testing.T) {
+	members := createGroupMembers(3)
+	g := NewGroup(MajorityOpen, members...)
+
+	members[0].Trip()
+	// once closed, every transition so far was looked into
+	g.Close()
+
+	assert.Equal(t, []CircuitState{IsOpen, IsClosed, IsClosed}, g.States())
+}
+
+func TestGroupStopsWatchingWhenClosed(t *testing.T) {
+	members := createGroupMembers(3)
+	g := NewGroup(MajorityOpen, members...)
+	g.Close()
+
+	members[0].Trip()
+	members[1].Trip()
+
+	assert.Equal(t, IsClosed, members[2].State())
+}
+
+func TestGroupMembersCanRecoverOnTheirOwn(t *testing.T) {
+	members := createGroupMembers(2)
+	g := NewGroup(func(states []CircuitState) bool {
+		return states[0] == IsOpen
+	}, members...)
+	defer g.Close()
+
+	members[0].Trip()
+	assert.Eventually(t, func() bool {
+		return members[1].State() == IsOpen
+	}, time.Second, time.Millisecond)
+
+	// it was tripped rather than forced, so it goes on as usual
+	members[1].Reset()
+	assert.Equal(t, IsClosed, members[1].State())
+}
+
+func TestMajorityOpen(t *testing.T) {
+	assert.False(t, MajorityOpen(nil))
+	assert.False(t, MajorityOpen([]CircuitState{IsOpen, IsClosed}))
+	assert.True(t, MajorityOpen([]CircuitState{IsOpen, IsOpen, IsHalfOpen}))
+}
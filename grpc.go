@@ -0,0 +1,71 @@
+//go:build grpc
+
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryClientInterceptor runs every unary RPC through the circuit breaker.
+// Only codes telling the server is in trouble count as fails, e.g. a
+// NotFound is the caller's business. While open, calls get Unavailable.
+func UnaryClientInterceptor(cb *CircuitBreaker) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		// Once the circuit breaker gives up on the RPC, e.g. it timed out, it is
+		// cancelled, and we wait for it to be done so that reply is left alone
+		// by the time the caller gets it back
+		invokeCtx, cancel := context.WithCancel(ctx)
+		var invoking sync.Mutex
+		var rpcErr error
+		op := func() (interface{}, error) {
+			invoking.Lock()
+			defer invoking.Unlock()
+			if err := invokeCtx.Err(); err != nil {
+				// Given up on before it even started
+				return nil, err
+			}
+			rpcErr = invoker(invokeCtx, method, req, reply, cc, opts...)
+			if rpcErr != nil && !isGRPCFailure(rpcErr) {
+				// The server is fine, so as far as the circuit is concerned
+				// it is a success
+				return reply, nil
+			}
+			return reply, rpcErr
+		}
+
+		_, _, err := cb.call(invokeCtx, op, 0)
+		cancel()
+		invoking.Lock()
+		defer invoking.Unlock()
+		if err == nil {
+			return rpcErr
+		}
+
+		var callingErr *CallingError
+		switch {
+		case errors.As(err, &callingErr) && errors.Is(err, ErrServiceTimeout):
+			return status.Error(codes.DeadlineExceeded, err.Error())
+		case errors.As(err, &callingErr):
+			if _, ok := status.FromError(callingErr.Cause); ok {
+				// What the server said is what the caller gets
+				return callingErr.Cause
+			}
+		}
+		return status.Error(codes.Unavailable, err.Error())
+	}
+}
+
+func isGRPCFailure(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Internal, codes.Unknown:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,129 @@
+//go:build grpc
+
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// Health server that fails with the given code
+type failingHealthServer struct {
+	healthpb.UnimplementedHealthServer
+	code codes.Code
+	hits int32
+}
+
+func (s *failingHealthServer) Check(context.Context, *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	atomic.AddInt32(&s.hits, 1)
+	if s.code == codes.OK {
+		return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}, nil
+	}
+	return nil, status.Error(s.code, "Server says no")
+}
+
+// Health server that takes its time, until the RPC is cancelled
+type sleepyHealthServer struct {
+	healthpb.UnimplementedHealthServer
+	cancelled int32
+}
+
+func (s *sleepyHealthServer) Check(ctx context.Context, _ *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	select {
+	case <-ctx.Done():
+		atomic.StoreInt32(&s.cancelled, 1)
+		return nil, ctx.Err()
+	case <-time.After(time.Second):
+		return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}, nil
+	}
+}
+
+func dialHealthServer(t *testing.T, server healthpb.HealthServer, cb *CircuitBreaker) healthpb.HealthClient {
+	listener := bufconn.Listen(1024 * 1024)
+	grpcServer := grpc.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, server)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(UnaryClientInterceptor(cb)))
+	assert.Nil(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return healthpb.NewHealthClient(conn)
+}
+
+func createGRPCCircuitBreaker() *CircuitBreaker {
+	cb, _ := NewManualCircuitBreaker(CircuitSettings{})
+	return cb
+}
+
+func TestGRPCUnavailableTripsCircuit(t *testing.T) {
+	server := &failingHealthServer{code: codes.Unavailable}
+	cb := createGRPCCircuitBreaker()
+	client := dialHealthServer(t, server, cb)
+
+	for i := 0; i < cb.Settings.FailureThreshold; i++ {
+		_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+		assert.Equal(t, "Server says no", status.Convert(err).Message())
+	}
+	assert.Equal(t, IsOpen, cb.State())
+
+	_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.True(t, strings.Contains(status.Convert(err).Message(), circuitIsOpenMessage))
+	assert.Equal(t, int32(cb.Settings.FailureThreshold), atomic.LoadInt32(&server.hits))
+}
+
+func TestGRPCNotFoundDoesNotTripCircuit(t *testing.T) {
+	server := &failingHealthServer{code: codes.NotFound}
+	cb := createGRPCCircuitBreaker()
+	client := dialHealthServer(t, server, cb)
+
+	for i := 0; i < cb.Settings.FailureThreshold+1; i++ {
+		_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	}
+	assert.Equal(t, IsClosed, cb.State())
+	assert.Equal(t, 0, cb.CurrentFailureCount())
+}
+
+func TestGRPCSuccessGoesThrough(t *testing.T) {
+	server := &failingHealthServer{code: codes.OK}
+	cb := createGRPCCircuitBreaker()
+	client := dialHealthServer(t, server, cb)
+
+	res, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, res.Status)
+	assert.Equal(t, int64(1), cb.Metrics().TotalSuccesses)
+}
+
+func TestGRPCTimeoutCancelsRPC(t *testing.T) {
+	server := &sleepyHealthServer{}
+	cb, _ := NewManualCircuitBreaker(CircuitSettings{Timeout: 20 * time.Millisecond})
+	client := dialHealthServer(t, server, cb)
+
+	res, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	assert.Nil(t, res)
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&server.cancelled) == 1
+	}, time.Second, time.Millisecond)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(body []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(body)
+}
+
+// Handler wraps an http.Handler which depends on something flaky, e.g. a
+// database, into a circuit breaker. While open, requests get a 503 rather
+// than reaching the handler, along with a Retry-After unless it is forced
+// open. Otherwise a 5xx from the handler is taken as a fail and anything else
+// as a success.
+func Handler(cb *CircuitBreaker, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		allowed := cb.Allow()
+		if allowed && cb.refreshState() == IsHalfOpen {
+			// Just like it is for Call, only so many requests at once get to
+			// give it a chance
+			if allowed = cb.acquireHalfOpenCall(); allowed {
+				defer cb.releaseHalfOpenCall()
+			}
+		}
+		if !allowed {
+			// Unless forced open, as then there is no telling when it is worth
+			// trying again
+			if !cb.isForcedOpen() {
+				w.Header().Set("Retry-After", retryAfter(cb.TimeUntilHalfOpen()))
+			}
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, req)
+		if recorder.status >= http.StatusInternalServerError {
+			cb.ReportFailure(fmt.Errorf("Handler responded with status %d", recorder.status))
+			return
+		}
+		cb.ReportSuccess()
+	})
+}
+
+// retryAfter gives the duration in whole seconds, never less than one
+func retryAfter(d time.Duration) string {
+	seconds := int64((d + time.Second - 1) / time.Second)
+	return strconv.FormatInt(max(seconds, 1), 10)
+}
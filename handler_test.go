@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func createStatusHandler(status int, hits *int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*hits++
+		w.WriteHeader(status)
+		w.Write([]byte(http.StatusText(status)))
+	})
+}
+
+func serve(handler http.Handler) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	return recorder
+}
+
+func TestHandlerPassesThroughWhileClosed(t *testing.T) {
+	hits := 0
+	cb, _ := NewManualCircuitBreaker(CircuitSettings{})
+	handler := Handler(cb, createStatusHandler(http.StatusOK, &hits))
+
+	for i := 0; i < 3; i++ {
+		resp := serve(handler)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Equal(t, http.StatusText(http.StatusOK), resp.Body.String())
+	}
+	assert.Equal(t, 3, hits)
+	assert.Equal(t, IsClosed, cb.State())
+}
+
+func TestHandlerRespondsUnavailableWhileOpen(t *testing.T) {
+	hits := 0
+	clock := newFakeClock()
+	cb, _ := NewManualCircuitBreaker(CircuitSettings{Clock: clock})
+	handler := Handler(cb, createStatusHandler(http.StatusInternalServerError, &hits))
+
+	for i := 0; i < cb.Settings.FailureThreshold; i++ {
+		resp := serve(handler)
+		assert.Equal(t, http.StatusInternalServerError, resp.Code)
+	}
+	assert.Equal(t, IsOpen, cb.State())
+
+	resp := serve(handler)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.Code)
+	assert.Equal(t, "3", resp.Header().Get("Retry-After"))
+	assert.Equal(t, cb.Settings.FailureThreshold, hits)
+
+	clock.Advance(2500 * time.Millisecond)
+	resp = serve(handler)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.Code)
+	assert.Equal(t, "1", resp.Header().Get("Retry-After"))
+	assert.Equal(t, cb.Settings.FailureThreshold, hits)
+}
+
+func TestHandlerTellsNoRetryAfterWhileForcedOpen(t *testing.T) {
+	hits := 0
+	cb, _ := NewManualCircuitBreaker(CircuitSettings{})
+	handler := Handler(cb, createStatusHandler(http.StatusInternalServerError, &hits))
+
+	serve(handler)
+	cb.ForceOpen()
+
+	resp := serve(handler)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.Code)
+	assert.Empty(t, resp.Header().Get("Retry-After"))
+	assert.Equal(t, 1, hits)
+}
+
+func TestHandlerClientErrorsAreNoFails(t *testing.T) {
+	hits := 0
+	cb, _ := NewManualCircuitBreaker(CircuitSettings{})
+	handler := Handler(cb, createStatusHandler(http.StatusNotFound, &hits))
+
+	for i := 0; i < cb.Settings.FailureThreshold+1; i++ {
+		resp := serve(handler)
+		assert.Equal(t, http.StatusNotFound, resp.Code)
+	}
+	assert.Equal(t, IsClosed, cb.State())
+}
+
+func TestHandlerHonorsHalfOpenMaxCalls(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := NewManualCircuitBreaker(CircuitSettings{Clock: clock, HalfOpenMaxCalls: 1})
+	cb.Trip()
+	clock.Advance(cb.Settings.RetryTimePeriod + time.Millisecond)
+
+	hits := 0
+	var handler http.Handler
+	handler = Handler(cb, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		if hits == 1 {
+			// another request comes in while this one is giving it a chance
+			resp := serve(handler)
+			assert.Equal(t, http.StatusServiceUnavailable, resp.Code)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	resp := serve(handler)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, 1, hits)
+	assert.Equal(t, IsClosed, cb.State())
+}
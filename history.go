@@ -0,0 +1,35 @@
+package main
+
+import (
+	"time"
+)
+
+// StateTransition is a change of state of the circuit
+type StateTransition struct {
+	From CircuitState
+	To   CircuitState
+	At   time.Time
+}
+
+// History gives a copy of the most recent state transitions, oldest first
+func (cb *CircuitBreaker) History() []StateTransition {
+	cb.mutex.RLock()
+	defer cb.mutex.RUnlock()
+
+	history := make([]StateTransition, len(cb.history))
+	copy(history, cb.history)
+	return history
+}
+
+func (cb *CircuitBreaker) recordTransition(from, to CircuitState) StateTransition {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	transition := StateTransition{From: from, To: to, At: cb.clock.Now()}
+	cb.history = append(cb.history, transition)
+	if excess := len(cb.history) - cb.Settings.MaxHistory; excess > 0 {
+		// Only the most recent ones are worth keeping
+		cb.history = cb.history[excess:]
+	}
+	return transition
+}
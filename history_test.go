@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistoryOfNewCircuitBreaker(t *testing.T) {
+	cb, _ := createCircuitBreaker(healthService, fallback)
+	cb.Call()
+	assert.Empty(t, cb.History())
+}
+
+func TestHistoryRecordsTransitions(t *testing.T) {
+	cb, _ := createCircuitBreakerWithRetryTimePeriod(failingService, fallback, 100*time.Millisecond)
+	start := time.Now()
+
+	for i := 0; i < cb.Settings.FailureThreshold; i++ {
+		cb.Call()
+	}
+	time.Sleep(cb.Settings.RetryTimePeriod)
+	cb.Call()
+
+	history := cb.History()
+	assert.Equal(t, 3, len(history))
+	expected := []StateTransition{
+		{From: IsClosed, To: IsOpen},
+		{From: IsOpen, To: IsHalfOpen},
+		{From: IsHalfOpen, To: IsOpen},
+	}
+	for i, transition := range history {
+		assert.Equal(t, expected[i].From, transition.From)
+		assert.Equal(t, expected[i].To, transition.To)
+		assert.False(t, transition.At.Before(start))
+		if i > 0 {
+			assert.False(t, transition.At.Before(history[i-1].At))
+		}
+	}
+	assert.True(t, history[1].At.Sub(history[0].At) >= cb.Settings.RetryTimePeriod)
+}
+
+func TestHistoryShouldBeBounded(t *testing.T) {
+	cb, _ := createCircuitBreaker(healthService, fallback)
+	cb.Settings.MaxHistory = 3
+
+	for i := 0; i < 5; i++ {
+		cb.Trip()
+		cb.Reset()
+	}
+
+	history := cb.History()
+	assert.Equal(t, 3, len(history))
+	assert.Equal(t, IsClosed, history[2].To)
+	assert.Equal(t, IsOpen, history[1].To)
+	assert.Equal(t, IsClosed, history[0].To)
+}
+
+func TestHistoryRecordsTransitionsOnClock(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := createCircuitBreakerWithClock(healthService, fallback, clock)
+
+	cb.Trip()
+	clock.Advance(time.Minute)
+	cb.Reset()
+
+	history := cb.History()
+	assert.Equal(t, 2, len(history))
+	assert.Equal(t, time.Minute, history[1].At.Sub(history[0].At))
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+)
+
+// logTransition must be called with the event lock held
+func (cb *CircuitBreaker) logTransition(from, to CircuitState) {
+	if cb.Settings.Logger == nil {
+		return
+	}
+
+	cb.mutex.RLock()
+	failures := cb.FailureCount
+	cb.mutex.RUnlock()
+
+	level := slog.LevelInfo
+	message := "Circuit changed state"
+	switch to {
+	case IsOpen:
+		// Something is wrong down there, so it is worth a warning
+		level = slog.LevelWarn
+		message = "Circuit tripped"
+	case IsHalfOpen:
+		message = "Circuit is giving the service a chance"
+	case IsClosed:
+		message = "Circuit reset"
+	}
+	cb.logAttrs(level, message,
+		slog.String("state", to.ToString()),
+		slog.String("previous_state", from.ToString()),
+		slog.Int("failure_count", failures))
+}
+
+// logFailure must be called with the event lock held
+func (cb *CircuitBreaker) logFailure(err error) {
+	if cb.Settings.Logger == nil {
+		return
+	}
+
+	cb.mutex.RLock()
+	state := cb.state()
+	failures := cb.FailureCount
+	cb.mutex.RUnlock()
+
+	cb.logAttrs(slog.LevelInfo, "Service failed",
+		slog.String("state", state.ToString()),
+		slog.Int("failure_count", failures),
+		slog.Any("error", err))
+}
+
+func (cb *CircuitBreaker) logAttrs(level slog.Level, message string, attrs ...slog.Attr) {
+	if cb.Settings.Name != "" {
+		// So that many circuits may share one logger
+		attrs = append(attrs, slog.String("name", cb.Settings.Name))
+	}
+	cb.Settings.Logger.LogAttrs(context.Background(), level, message, attrs...)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Keeps whatever gets logged
+type recordingHandler struct {
+	mutex   sync.Mutex
+	records []slog.Record
+}
+
+func (h *recordingHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+func (h *recordingHandler) Handle(_ context.Context, record slog.Record) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.records = append(h.records, record)
+	return nil
+}
+
+func (h *recordingHandler) WithAttrs([]slog.Attr) slog.Handler {
+	return h
+}
+
+func (h *recordingHandler) WithGroup(string) slog.Handler {
+	return h
+}
+
+func attrsOf(record slog.Record) map[string]slog.Value {
+	attrs := map[string]slog.Value{}
+	record.Attrs(func(attr slog.Attr) bool {
+		attrs[attr.Key] = attr.Value
+		return true
+	})
+	return attrs
+}
+
+func TestTripIsLoggedAsWarning(t *testing.T) {
+	handler := &recordingHandler{}
+	cb, _ := createCircuitBreaker(failingService, fallback)
+	cb.Settings.Logger = slog.New(handler)
+
+	for i := 0; i < cb.Settings.FailureThreshold; i++ {
+		cb.Call()
+	}
+
+	var trips []slog.Record
+	for _, record := range handler.records {
+		if record.Level == slog.LevelWarn {
+			trips = append(trips, record)
+		}
+	}
+	assert.Equal(t, 1, len(trips))
+	attrs := attrsOf(trips[0])
+	assert.Equal(t, "open", attrs["state"].String())
+	assert.Equal(t, int64(cb.Settings.FailureThreshold), attrs["failure_count"].Int64())
+}
+
+func TestFailuresAreLogged(t *testing.T) {
+	handler := &recordingHandler{}
+	cb, _ := createCircuitBreaker(failingService, fallback)
+	cb.Settings.Logger = slog.New(handler)
+
+	cb.Call()
+	assert.Equal(t, 1, len(handler.records))
+	record := handler.records[0]
+	assert.Equal(t, slog.LevelInfo, record.Level)
+	attrs := attrsOf(record)
+	assert.Equal(t, "closed", attrs["state"].String())
+	assert.Equal(t, int64(1), attrs["failure_count"].Int64())
+	assert.Equal(t, failingServiceError, attrs["error"].Any())
+}
+
+func TestResetIsLogged(t *testing.T) {
+	handler := &recordingHandler{}
+	cb, _ := createCircuitBreaker(healthService, fallback)
+	cb.Settings.Logger = slog.New(handler)
+
+	cb.Trip()
+	cb.Reset()
+	assert.Equal(t, 2, len(handler.records))
+	assert.Equal(t, slog.LevelWarn, handler.records[0].Level)
+	assert.Equal(t, slog.LevelInfo, handler.records[1].Level)
+	attrs := attrsOf(handler.records[1])
+	assert.Equal(t, "closed", attrs["state"].String())
+	assert.Equal(t, "open", attrs["previous_state"].String())
+	assert.Equal(t, int64(0), attrs["failure_count"].Int64())
+}
+
+func TestNameIsLogged(t *testing.T) {
+	handler := &recordingHandler{}
+	cb, _ := createCircuitBreaker(healthService, fallback)
+	cb.Settings.Name = "payments"
+	cb.Settings.Logger = slog.New(handler)
+
+	cb.Trip()
+	assert.Equal(t, 1, len(handler.records))
+	assert.Equal(t, "payments", attrsOf(handler.records[0])["name"].String())
+}
+
+func TestNothingIsLoggedWithoutLogger(t *testing.T) {
+	cb, _ := createCircuitBreaker(failingService, fallback)
+
+	assert.NotPanics(t, func() {
+		for i := 0; i < cb.Settings.FailureThreshold+1; i++ {
+			cb.Call()
+		}
+	})
+}
@@ -25,6 +25,10 @@ func printState(st CircuitState) {
 	fmt.Printf("--- circuit state (%s) ---\n", st.ToString())
 }
 
+func printReadiness(ready bool) {
+	fmt.Printf("--- readiness check (ready=%t) ---\n", ready)
+}
+
 func printStateChanged(st CircuitState) {
 	fmt.Printf("--- circuit state changed (%s) ---\n", st.ToString())
 }
@@ -49,22 +53,27 @@ func await() {
 func main() {
 	printHead("My Always Health Service")
 	cb, _ := createCircuitBreaker(healthService, fallback)
-	cb.Settings.OnStateChange = func() {
-		printStateChanged(cb.State())
-	}
+	cb.Configure(func(s *CircuitSettings) {
+		s.OnStateChange = func() {
+			printStateChanged(cb.State())
+		}
+	})
 	for i := 0; i < 3; i++ {
 		res, fallbacked, err := cb.Call()
 		printResponse(res, fallbacked, err)
 	}
 
 	printHead("My Always Slow Service")
-	cb, _ = createCircuitBreaker(slowService, fallback)
-	cb.Settings.OnStateChange = func() {
-		printStateChanged(cb.State())
-	}
-	cb.Settings.OnTrip = func() {
-		printTripped(cb.FailureCount)
-	}
+	// Any static content will do as a fallback
+	cb, _ = createCircuitBreaker(slowService, StaticFallback(fallbackContent))
+	cb.Configure(func(s *CircuitSettings) {
+		s.OnStateChange = func() {
+			printStateChanged(cb.State())
+		}
+		s.OnTrip = func() {
+			printTripped(cb.CurrentFailureCount())
+		}
+	})
 	for i := 0; i < 10; i++ {
 		res, fallbacked, err := cb.Call()
 		printResponse(res, fallbacked, err)
@@ -77,22 +86,27 @@ func main() {
 
 	printHead("My Intermittently Slow Service")
 	cb, _ = createCircuitBreaker(countdownToHealthService, fallback)
-	cb.Settings.OnStateChange = func() {
-		printStateChanged(cb.State())
-	}
-	cb.Settings.OnTrip = func() {
-		printTripped(cb.FailureCount)
-	}
-	cb.Settings.OnReset = func() {
-		printResetted(cb.FailureCount)
-	}
+	cb.Configure(func(s *CircuitSettings) {
+		s.OnStateChange = func() {
+			printStateChanged(cb.State())
+		}
+		s.OnTrip = func() {
+			printTripped(cb.CurrentFailureCount())
+		}
+		s.OnReset = func() {
+			printResetted(cb.CurrentFailureCount())
+		}
+	})
 	for i := 0; i < 10; i++ {
 		res, fallbacked, err := cb.Call()
 		printResponse(res, fallbacked, err)
 
 		if i == 5 || i == 7 {
+			// e.g. what a readiness probe of yours would tell
+			printReadiness(cb.Healthy())
 			await()
 			printState(cb.State())
 		}
 	}
+	printReadiness(cb.Healthy())
 }
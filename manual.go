@@ -0,0 +1,45 @@
+package main
+
+// Allow tells whether a call would go through right now, i.e. the circuit is
+// not open, for callers who call the service on their own and then report
+// how it went with ReportSuccess or ReportFailure
+func (cb *CircuitBreaker) Allow() bool {
+	if cb.isShutdown() {
+		return false
+	}
+	return cb.refreshState() != IsOpen
+}
+
+// ReportSuccess tells the circuit that a call to the service went fine
+func (cb *CircuitBreaker) ReportSuccess() {
+	cb.report(func(state CircuitState) {
+		cb.recordSuccess(state, false)
+	})
+}
+
+// ReportFailure tells the circuit that a call to the service failed
+func (cb *CircuitBreaker) ReportFailure(err error) {
+	cb.report(func(state CircuitState) {
+		cb.recordFailure(state, err)
+		cb.notifyFailure(err)
+	})
+}
+
+func (cb *CircuitBreaker) report(record func(state CircuitState)) {
+	if cb.isShutdown() {
+		return
+	}
+
+	cb.countCall()
+
+	state := cb.refreshState()
+
+	cb.eventMutex.Lock()
+	defer cb.eventMutex.Unlock()
+
+	// Just like it is for Call, nothing new is learned while open
+	if state != IsOpen {
+		record(state)
+	}
+	cb.notifyState(cb.State())
+}
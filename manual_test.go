@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllowAndReportDriveTrip(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := createCircuitBreakerWithClock(healthService, fallback, clock)
+
+	tripCount := 0
+	cb.Settings.OnTrip = func() {
+		tripCount++
+	}
+
+	for i := 0; i < cb.Settings.FailureThreshold; i++ {
+		assert.True(t, cb.Allow())
+		cb.ReportFailure(failingServiceError)
+	}
+	assert.False(t, cb.Allow())
+	assert.Equal(t, IsOpen, cb.State())
+	assert.Equal(t, 1, tripCount)
+	assert.Equal(t, cb.Settings.FailureThreshold, cb.CurrentFailureCount())
+	assert.Contains(t, cb.FailureRecord[0], failingServiceError.Error())
+
+	clock.Advance(cb.Settings.RetryTimePeriod + time.Millisecond)
+	assert.True(t, cb.Allow())
+	cb.ReportSuccess()
+	assert.Equal(t, IsClosed, cb.State())
+	assert.Equal(t, 0, cb.CurrentFailureCount())
+}
+
+func TestReportSuccessClearsFailures(t *testing.T) {
+	cb, _ := createCircuitBreaker(healthService, fallback)
+
+	cb.ReportFailure(failingServiceError)
+	assert.Equal(t, 1, cb.CurrentFailureCount())
+
+	cb.ReportSuccess()
+	assert.Equal(t, 0, cb.CurrentFailureCount())
+	assert.Equal(t, int64(2), cb.Metrics().TotalCalls)
+}
+
+func TestReportWhileOpenIsIgnored(t *testing.T) {
+	cb, _ := createCircuitBreaker(healthService, fallback)
+	cb.Trip()
+
+	failures := cb.CurrentFailureCount()
+	cb.ReportFailure(failingServiceError)
+	assert.Equal(t, failures, cb.CurrentFailureCount())
+
+	cb.ReportSuccess()
+	assert.Equal(t, IsOpen, cb.State())
+}
+
+func TestReportFailureWithNoError(t *testing.T) {
+	cb, _ := createCircuitBreaker(healthService, fallback)
+
+	cb.ReportFailure(nil)
+	assert.Equal(t, 1, cb.CurrentFailureCount())
+}
+
+func TestManualCircuitBreakerTripsAndRecoversWithNoService(t *testing.T) {
+	clock := newFakeClock()
+	cb, err := NewManualCircuitBreaker(CircuitSettings{Clock: clock})
+	assert.Nil(t, err)
+	assert.Nil(t, cb.Settings.Service)
+
+	var transitions []CircuitState
+	cb.Settings.OnStateChange = func() {
+		transitions = append(transitions, cb.State())
+	}
+
+	for cb.Allow() {
+		cb.ReportFailure(failingServiceError)
+	}
+	assert.Equal(t, IsOpen, cb.State())
+	assert.Equal(t, DefautlFailureThreshold, cb.CurrentFailureCount())
+
+	clock.Advance(cb.Settings.RetryTimePeriod + time.Millisecond)
+	assert.True(t, cb.Allow())
+	cb.ReportSuccess()
+	assert.Equal(t, IsClosed, cb.State())
+	assert.Equal(t, []CircuitState{IsOpen, IsHalfOpen, IsClosed}, transitions)
+}
+
+func TestManualCircuitBreakerRejectsBadSettings(t *testing.T) {
+	cb, err := NewManualCircuitBreaker(CircuitSettings{Timeout: -1})
+	assert.Nil(t, cb)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// FallbackMeta tells about the content a fallback responded
+type FallbackMeta struct {
+	// How old the content is, e.g. since it was cached, zero means fresh or
+	// unknown
+	Age time.Duration
+}
+
+// MetaFallbackFunc is a fallback that tells about its content too
+type MetaFallbackFunc func() (interface{}, FallbackMeta, error)
+
+type metaKey struct{}
+
+// CallWithMeta is the same as Call but it also tells what FallbackWithMeta
+// had to say about its content, if it was the one to make it, so callers
+// can tell fresh content apart from stale one
+func (cb *CircuitBreaker) CallWithMeta() (interface{}, bool, FallbackMeta, error) {
+	var meta FallbackMeta
+	ctx := context.WithValue(context.Background(), metaKey{}, &meta)
+	res, fallbacked, err := cb.call(ctx, nil, 0)
+	if !fallbacked {
+		// Whatever it said, it was not used in the end
+		meta = FallbackMeta{}
+	}
+	return res, fallbacked, meta, err
+}
+
+// keepMeta hands the meta over to CallWithMeta, when it is the caller
+func keepMeta(ctx context.Context, meta FallbackMeta) {
+	if dest, ok := ctx.Value(metaKey{}).(*FallbackMeta); ok {
+		*dest = meta
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func staleFallback(age time.Duration) MetaFallbackFunc {
+	return func() (interface{}, FallbackMeta, error) {
+		return fallbackContent, FallbackMeta{Age: age}, nil
+	}
+}
+
+func TestMetaAgePassedThrough(t *testing.T) {
+	cb, _ := createCircuitBreakerWithNoFallback(failingService)
+	cb.Settings.FallbackWithMeta = staleFallback(90 * time.Second)
+
+	res, fallbacked, meta, err := cb.CallWithMeta()
+	assert.Equal(t, fallbackContent, res)
+	assert.True(t, fallbacked)
+	assert.Equal(t, 90*time.Second, meta.Age)
+	assert.NotNil(t, err)
+}
+
+func TestMetaAgePassedThroughWhenOpen(t *testing.T) {
+	cb, _ := createCircuitBreakerWithNoFallback(healthService)
+	cb.Settings.FallbackWithMeta = staleFallback(3 * time.Minute)
+	cb.Trip()
+
+	_, fallbacked, meta, err := cb.CallWithMeta()
+	assert.True(t, fallbacked)
+	assert.Equal(t, 3*time.Minute, meta.Age)
+	assert.True(t, errors.Is(err, ErrCircuitOpen))
+}
+
+func TestMetaEmptyWhenNotFallbacked(t *testing.T) {
+	cb, _ := createCircuitBreakerWithNoFallback(healthService)
+	cb.Settings.FallbackWithMeta = staleFallback(time.Minute)
+
+	res, fallbacked, meta, err := cb.CallWithMeta()
+	assert.Equal(t, healthServiceContent, res)
+	assert.False(t, fallbacked)
+	assert.Equal(t, FallbackMeta{}, meta)
+	assert.Nil(t, err)
+}
+
+func TestMetaEmptyWhenNextFallbackMadeIt(t *testing.T) {
+	cb, _ := createCircuitBreakerWithNoFallback(failingService)
+	cb.Settings.FallbackWithMeta = func() (interface{}, FallbackMeta, error) {
+		return nil, FallbackMeta{Age: time.Minute}, errors.New("Cache is empty")
+	}
+	cb.Settings.Fallbacks = []Callable{fallback}
+
+	res, fallbacked, meta, _ := cb.CallWithMeta()
+	assert.Equal(t, fallbackContent, res)
+	assert.True(t, fallbacked)
+	assert.Equal(t, FallbackMeta{}, meta)
+}
+
+func TestMetaFallbackWorksWithCall(t *testing.T) {
+	cb, _ := createCircuitBreakerWithNoFallback(failingService)
+	cb.Settings.FallbackWithMeta = staleFallback(time.Minute)
+
+	res, fallbacked, _ := cb.Call()
+	assert.Equal(t, fallbackContent, res)
+	assert.True(t, fallbacked)
+}
@@ -0,0 +1,64 @@
+package main
+
+import "time"
+
+// Metrics is a snapshot of how a circuit breaker has been doing so far
+type Metrics struct {
+	// State of the circuit at the time of the snapshot
+	State CircuitState
+	// How many times it was called
+	TotalCalls int64
+	// How many calls ended up as a fail
+	TotalFailures int64
+	// How many calls ended up as a success
+	TotalSuccesses int64
+	// How many times the fallback was called
+	TotalFallbacks int64
+	// How many times the service timed out
+	TotalTimeouts int64
+	// How long service calls take on average
+	AverageLatency time.Duration
+}
+
+// MetricsHook is told about calls as they go, so that metrics can be pushed
+// to whatever backend, e.g. StatsD, rather than scraped
+type MetricsHook interface {
+	// A call is on its way, be it to the service or not
+	CallStarted()
+	// The service responded fine, along with how long it took
+	CallSucceeded(latency time.Duration)
+	// The call ended up as a fail, along with what went wrong
+	CallFailed(err error)
+	// The fallback was called
+	Fallbacked()
+	// The circuit changed state
+	StateChanged(from, to CircuitState)
+}
+
+// Metrics gives a consistent snapshot of the circuit breaker counters
+func (cb *CircuitBreaker) Metrics() Metrics {
+	cb.mutex.RLock()
+	defer cb.mutex.RUnlock()
+
+	metrics := cb.metrics
+	metrics.State = cb.state()
+	return metrics
+}
+
+// LastLatency tells how long the latest service call took
+func (cb *CircuitBreaker) LastLatency() time.Duration {
+	cb.mutex.RLock()
+	defer cb.mutex.RUnlock()
+
+	return cb.lastLatency
+}
+
+func (cb *CircuitBreaker) recordLatency(latency time.Duration) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	cb.lastLatency = latency
+	cb.latencySamples++
+	// Running average, so there is no need to keep every sample around
+	cb.metrics.AverageLatency += (latency - cb.metrics.AverageLatency) / time.Duration(cb.latencySamples)
+}
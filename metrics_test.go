@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsOfNewCircuitBreaker(t *testing.T) {
+	cb, _ := createCircuitBreaker(healthService, fallback)
+	assert.Equal(t, Metrics{State: IsClosed}, cb.Metrics())
+}
+
+func TestMetricsAfterMixedCalls(t *testing.T) {
+	cb, _ := createCircuitBreaker(healthService, fallback)
+
+	for i := 0; i < 2; i++ {
+		cb.Call()
+	}
+	cb.Settings.Service = slowService
+	for i := 0; i < cb.Settings.FailureThreshold; i++ {
+		cb.Call()
+	}
+	// this one goes for fallback with no service call at all
+	cb.Call()
+
+	metrics := cb.Metrics()
+	// two quick calls and two timed out ones
+	assert.GreaterOrEqual(t, metrics.AverageLatency, cb.Settings.Timeout/2)
+	assert.Less(t, metrics.AverageLatency, cb.Settings.Timeout)
+	metrics.AverageLatency = 0
+
+	assert.Equal(t, Metrics{
+		State:          IsOpen,
+		TotalCalls:     5,
+		TotalFailures:  2,
+		TotalSuccesses: 2,
+		TotalFallbacks: 3,
+		TotalTimeouts:  2,
+	}, metrics)
+}
+
+func TestLastLatencyOfNewCircuitBreaker(t *testing.T) {
+	cb, _ := createCircuitBreaker(healthService, fallback)
+	assert.Equal(t, time.Duration(0), cb.LastLatency())
+}
+
+func TestLatencyIsMeasuredAroundServiceCall(t *testing.T) {
+	cb, _ := createCircuitBreaker(createSleepyService(50*time.Millisecond), fallback)
+
+	cb.Call()
+	assert.GreaterOrEqual(t, cb.LastLatency(), 50*time.Millisecond)
+	assert.GreaterOrEqual(t, cb.Metrics().AverageLatency, 50*time.Millisecond)
+
+	cb.Settings.Service = createSleepyService(150 * time.Millisecond)
+	cb.Call()
+	assert.GreaterOrEqual(t, cb.LastLatency(), 150*time.Millisecond)
+	assert.GreaterOrEqual(t, cb.Metrics().AverageLatency, 100*time.Millisecond)
+	assert.Less(t, cb.Metrics().AverageLatency, cb.LastLatency())
+}
+
+func TestLatencyIsNotMeasuredWhenOpen(t *testing.T) {
+	cb, _ := createCircuitBreaker(createSleepyService(50*time.Millisecond), createSleepyService(100*time.Millisecond))
+	cb.Trip()
+
+	cb.Call()
+	assert.Equal(t, time.Duration(0), cb.LastLatency())
+	assert.Equal(t, time.Duration(0), cb.Metrics().AverageLatency)
+}
+
+// Keeps track of which hooks were called, in order
+type recordingHook struct {
+	calls []string
+}
+
+func (h *recordingHook) CallStarted() {
+	h.calls = append(h.calls, "started")
+}
+
+func (h *recordingHook) CallSucceeded(time.Duration) {
+	h.calls = append(h.calls, "succeeded")
+}
+
+func (h *recordingHook) CallFailed(err error) {
+	h.calls = append(h.calls, fmt.Sprintf("failed: %s", err))
+}
+
+func (h *recordingHook) Fallbacked() {
+	h.calls = append(h.calls, "fallbacked")
+}
+
+func (h *recordingHook) StateChanged(from, to CircuitState) {
+	h.calls = append(h.calls, fmt.Sprintf("%s -> %s", from.ToString(), to.ToString()))
+}
+
+func TestMetricsHookSequence(t *testing.T) {
+	hook := &recordingHook{}
+	cb, _ := createCircuitBreaker(healthService, fallback)
+	cb.Settings.MetricsHook = hook
+
+	cb.Call()
+	assert.Equal(t, []string{"started", "succeeded"}, hook.calls)
+
+	hook.calls = nil
+	cb.Settings.Service = failingService
+	for i := 0; i < cb.Settings.FailureThreshold+1; i++ {
+		cb.Call()
+	}
+	assert.Equal(t, []string{
+		"started", "fallbacked", "failed: Service is failing",
+		"started", "fallbacked", "failed: Service is failing", "closed -> open",
+		"started", "fallbacked",
+	}, hook.calls)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Option tweaks the settings spec of a circuit breaker built by New
+type Option func(*CircuitSettings)
+
+// New builds a circuit breaker for a service, as much as NewCircuitBreaker
+// does, but with options instead of a settings spec
+func New(service Callable, opts ...Option) (*CircuitBreaker, error) {
+	settings := CircuitSettings{Service: service}
+	for _, opt := range opts {
+		opt(&settings)
+	}
+	return NewCircuitBreaker(settings)
+}
+
+// Configure changes the settings spec of a circuit breaker that may be in use
+// already, e.g. to set a callback which needs the circuit breaker itself. The
+// changes go through the same checks and defaults as NewCircuitBreaker, and
+// calls on their way keep going by the settings they started with. Clock,
+// RandSource and MaxConcurrentCalls are only taken when it is built, so
+// changes to them are left out. It must not be called from within a callback.
+func (cb *CircuitBreaker) Configure(fn func(*CircuitSettings)) error {
+	// One change at a time, and none while callbacks are running
+	cb.eventMutex.Lock()
+	defer cb.eventMutex.Unlock()
+
+	// It goes on a copy, so nobody ever sees it half done
+	current := cb.CurrentSettings()
+	settings := current
+	fn(&settings)
+	settings.Clock = current.Clock
+	settings.RandSource = current.RandSource
+	settings.MaxConcurrentCalls = current.MaxConcurrentCalls
+
+	if settings.Service == nil && current.Service != nil {
+		return fmt.Errorf("You must provide a service to be called")
+	}
+	if err := validateSettings(settings); err != nil {
+		return err
+	}
+
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	if settings.RollingWindowSize != current.RollingWindowSize {
+		// The ring is as big as the window was, so it starts over
+		cb.clearOutcomes()
+	}
+	cb.Settings = withDefaults(settings)
+	return nil
+}
+
+// CurrentSettings gives a copy of the settings spec, as of now
+func (cb *CircuitBreaker) CurrentSettings() CircuitSettings {
+	cb.mutex.RLock()
+	defer cb.mutex.RUnlock()
+
+	return cb.Settings
+}
+
+// WithFallback sets the fallback for when service is unhealth
+func WithFallback(fallback Callable) Option {
+	return func(s *CircuitSettings) {
+		s.Fallback = fallback
+	}
+}
+
+// WithTimeout sets the request timeout
+func WithTimeout(timeout time.Duration) Option {
+	return func(s *CircuitSettings) {
+		s.Timeout = timeout
+	}
+}
+
+// WithRetryPeriod sets the grace time to wait before a new call to the service
+func WithRetryPeriod(period time.Duration) Option {
+	return func(s *CircuitSettings) {
+		s.RetryTimePeriod = period
+	}
+}
+
+// WithFailureThreshold sets how many fails should we tolerate
+func WithFailureThreshold(threshold int) Option {
+	return func(s *CircuitSettings) {
+		s.FailureThreshold = threshold
+	}
+}
+
+// WithOnTrip sets what happens when the circuit trips
+func WithOnTrip(onTrip CircuitEvent) Option {
+	return func(s *CircuitSettings) {
+		s.OnTrip = onTrip
+	}
+}
+
+// WithClock sets what tells the time, e.g. a fake one for tests
+func WithClock(clock Clock) Option {
+	return func(s *CircuitSettings) {
+		s.Clock = clock
+	}
+}
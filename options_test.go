@@ -0,0 +1,234 @@
+package main
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewErrorWithoutProvideAService(t *testing.T) {
+	cb, err := New(nil)
+	assert.NotNil(t, err)
+	assert.Nil(t, cb)
+}
+
+func TestNewWithDefaultSettings(t *testing.T) {
+	cb, err := New(healthService)
+	assert.Nil(t, err)
+	assert.Nil(t, cb.Settings.Fallback)
+	assert.Equal(t, DefautTimeout, cb.Settings.Timeout)
+	assert.Equal(t, DefaultRetryTimePeriod, cb.Settings.RetryTimePeriod)
+	assert.Equal(t, DefautlFailureThreshold, cb.Settings.FailureThreshold)
+}
+
+func TestNewWithOptions(t *testing.T) {
+	clock := newFakeClock()
+	cb, err := New(healthService,
+		WithFallback(fallback),
+		WithTimeout(time.Second),
+		WithRetryPeriod(time.Minute),
+		WithFailureThreshold(5),
+		WithOnTrip(func() {}),
+		WithClock(clock))
+	assert.Nil(t, err)
+	assert.NotNil(t, cb.Settings.Fallback)
+	assert.Equal(t, time.Second, cb.Settings.Timeout)
+	assert.Equal(t, time.Minute, cb.Settings.RetryTimePeriod)
+	assert.Equal(t, 5, cb.Settings.FailureThreshold)
+	assert.NotNil(t, cb.Settings.OnTrip)
+	assert.Equal(t, clock, cb.Settings.Clock)
+}
+
+func TestNewBehavesAsNewCircuitBreaker(t *testing.T) {
+	clock := newFakeClock()
+	var trips []string
+	cbs := map[string]*CircuitBreaker{}
+
+	cbs["options"], _ = New(failingService,
+		WithFallback(fallback),
+		WithRetryPeriod(time.Minute),
+		WithFailureThreshold(3),
+		WithOnTrip(func() { trips = append(trips, "options") }),
+		WithClock(clock))
+	cbs["settings"], _ = NewCircuitBreaker(CircuitSettings{
+		Service:          failingService,
+		Fallback:         fallback,
+		RetryTimePeriod:  time.Minute,
+		FailureThreshold: 3,
+		OnTrip:           func() { trips = append(trips, "settings") },
+		Clock:            clock,
+	})
+
+	for i := 0; i < 3; i++ {
+		for _, name := range []string{"options", "settings"} {
+			res, fallbacked, err := cbs[name].Call()
+			assert.Contains(t, err.Error(), fallbackDueToErrorMessage)
+			assert.True(t, fallbacked)
+			assert.Equal(t, fallbackContent, res)
+		}
+		assert.Equal(t, cbs["settings"].State(), cbs["options"].State())
+		assert.Equal(t, cbs["settings"].CurrentFailureCount(), cbs["options"].CurrentFailureCount())
+	}
+	assert.Equal(t, []string{"options", "settings"}, trips)
+
+	clock.Advance(time.Minute + time.Nanosecond)
+	assert.Equal(t, IsHalfOpen, cbs["options"].State())
+	assert.Equal(t, IsHalfOpen, cbs["settings"].State())
+}
+
+func TestConfigureWhileCalling(t *testing.T) {
+	var trips int32
+	onTrip := func() { atomic.AddInt32(&trips, 1) }
+	cb, _ := NewCircuitBreaker(CircuitSettings{
+		Service:          failingService,
+		Fallback:         fallback,
+		FailureThreshold: 1,
+		OnTrip:           onTrip,
+	})
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			cb.Call()
+			cb.Reset()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			cb.Configure(func(s *CircuitSettings) {
+				s.OnTrip = onTrip
+			})
+		}
+	}()
+	wg.Wait()
+
+	// whichever OnTrip it had at the time, every trip was told
+	assert.Equal(t, int32(100), atomic.LoadInt32(&trips))
+	assert.NotNil(t, cb.CurrentSettings().OnTrip)
+}
+
+func TestConfigureWhileCallingIsRaceFree(t *testing.T) {
+	cb, _ := NewCircuitBreaker(CircuitSettings{
+		Service:  healthService,
+		Fallback: fallback,
+	})
+
+	var wg sync.WaitGroup
+	for _, call := range []func(){
+		func() { cb.Call() },
+		func() { cb.CallWithTimeout(time.Second) },
+		func() { cb.CallWithReason() },
+		func() { cb.CallWithMeta() },
+		func() { cb.Execute(failingService) },
+		func() { cb.CurrentSettings() },
+	} {
+		wg.Add(1)
+		go func(call func()) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				call()
+			}
+		}(call)
+	}
+	for i := 0; i < 100; i++ {
+		err := cb.Configure(func(s *CircuitSettings) {
+			s.Name = "payments"
+			s.Timeout = time.Duration(i+1) * time.Second
+			s.Fallbacks = []Callable{fallback}
+			s.FailFast = i%2 == 0
+			s.ShadowMode = i%3 == 0
+			s.AllowNilResponse = i%2 == 1
+		})
+		assert.Nil(t, err)
+	}
+	wg.Wait()
+	assert.Equal(t, "payments", cb.CurrentSettings().Name)
+}
+
+func TestConfigureAppliesDefaults(t *testing.T) {
+	cb, _ := NewCircuitBreaker(CircuitSettings{Service: healthService, FailureThreshold: 5})
+
+	err := cb.Configure(func(s *CircuitSettings) {
+		s.Timeout = 0
+		s.FailureThreshold = 0
+	})
+	assert.Nil(t, err)
+	// rather than every call timing out right away or being half-open
+	assert.Equal(t, DefautTimeout, cb.Settings.Timeout)
+	assert.Equal(t, DefautlFailureThreshold, cb.Settings.FailureThreshold)
+	assert.Equal(t, IsClosed, cb.State())
+
+	res, fallbacked, err := cb.Call()
+	assert.Nil(t, err)
+	assert.False(t, fallbacked)
+	assert.Equal(t, healthServiceContent, res)
+}
+
+func TestConfigureRejectsInvalidSettings(t *testing.T) {
+	cb, _ := NewCircuitBreaker(CircuitSettings{Service: healthService, FailureThreshold: 5})
+
+	for _, fn := range []func(*CircuitSettings){
+		func(s *CircuitSettings) { s.FailureThreshold = -1 },
+		func(s *CircuitSettings) { s.Timeout = -time.Second },
+		func(s *CircuitSettings) { s.Service = nil },
+	} {
+		assert.NotNil(t, cb.Configure(fn))
+	}
+	// it is left as it was
+	assert.Equal(t, 5, cb.Settings.FailureThreshold)
+	assert.Equal(t, DefautTimeout, cb.Settings.Timeout)
+	assert.NotNil(t, cb.Settings.Service)
+}
+
+// A clock which can't be compared, as it is a struct value with a func
+type funcClock struct {
+	now func() time.Time
+}
+
+func (c funcClock) Now() time.Time {
+	return c.now()
+}
+
+func TestConfigureLeavesOutBuildTimeSettings(t *testing.T) {
+	cb, _ := NewCircuitBreaker(CircuitSettings{Service: healthService, Clock: funcClock{time.Now}})
+
+	err := cb.Configure(func(s *CircuitSettings) {
+		s.Clock = funcClock{time.Now}
+		s.RandSource = rand.NewSource(1)
+		s.MaxConcurrentCalls = 10
+		s.FailureThreshold = 5
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 5, cb.Settings.FailureThreshold)
+	assert.Zero(t, cb.Settings.MaxConcurrentCalls)
+	assert.Nil(t, cb.bulkhead)
+}
+
+func TestConfigureStartsRollingWindowOver(t *testing.T) {
+	cb, _ := NewCircuitBreaker(CircuitSettings{Service: failingService, Fallback: fallback, ErrorPercentThreshold: 50, RollingWindowSize: 10, MinRequestVolume: 10})
+	for i := 0; i < 6; i++ {
+		cb.Call()
+	}
+
+	err := cb.Configure(func(s *CircuitSettings) {
+		s.RollingWindowSize = 4
+		s.MinRequestVolume = 4
+	})
+	assert.Nil(t, err)
+	assert.Empty(t, cb.outcomes)
+
+	for i := 0; i < 3; i++ {
+		cb.Call()
+		assert.Equal(t, IsClosed, cb.State())
+	}
+	cb.Call()
+	assert.Equal(t, IsOpen, cb.State())
+	assert.Equal(t, 4, len(cb.outcomes))
+}
@@ -0,0 +1,47 @@
+//go:build otel
+
+package main
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Only built with the otel tag, so the circuit breaker itself doesn't depend
+// on OpenTelemetry.
+
+// CallWithTracer is the same as CallContext but the call goes within a span
+// of its own, named after the circuit, which tells the state, whether it was
+// fallbacked and the error, if any. A trip along the way is a span event.
+func (cb *CircuitBreaker) CallWithTracer(ctx context.Context, tracer trace.Tracer) (interface{}, bool, error) {
+	name := cb.CurrentSettings().Name
+	spanName := name
+	if spanName == "" {
+		spanName = "circuitbreaker"
+	}
+	ctx, span := tracer.Start(ctx, spanName)
+	defer span.End()
+
+	preState := cb.State()
+	res, fallbacked, err := cb.call(ctx, nil, 0)
+	state := cb.State()
+
+	if preState != IsOpen && state == IsOpen {
+		span.AddEvent("circuitbreaker.trip", trace.WithAttributes(
+			attribute.Int("circuitbreaker.failure_count", cb.CurrentFailureCount())))
+	}
+	span.SetAttributes(
+		attribute.String("circuitbreaker.state", preState.ToString()),
+		attribute.Bool("circuitbreaker.fallbacked", fallbacked))
+	if name != "" {
+		span.SetAttributes(attribute.String("circuitbreaker.name", name))
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return res, fallbacked, err
+}
@@ -0,0 +1,71 @@
+//go:build otel
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func createTracer() (*tracetest.InMemoryExporter, *sdktrace.TracerProvider) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	return exporter, provider
+}
+
+func attributesOf(span tracetest.SpanStub) map[string]string {
+	attrs := map[string]string{}
+	for _, attr := range span.Attributes {
+		attrs[string(attr.Key)] = attr.Value.Emit()
+	}
+	return attrs
+}
+
+func eventsOf(span tracetest.SpanStub) []string {
+	var events []string
+	for _, event := range span.Events {
+		events = append(events, event.Name)
+	}
+	return events
+}
+
+func TestCallWithTracerProducesSpan(t *testing.T) {
+	exporter, provider := createTracer()
+	cb, _ := createCircuitBreaker(healthService, fallback)
+	cb.Settings.Name = "payments"
+
+	res, _, err := cb.CallWithTracer(context.Background(), provider.Tracer("test"))
+	assert.Nil(t, err)
+	assert.Equal(t, healthServiceContent, res)
+
+	spans := exporter.GetSpans()
+	assert.Equal(t, 1, len(spans))
+	assert.Equal(t, "payments", spans[0].Name)
+	attrs := attributesOf(spans[0])
+	assert.Equal(t, "closed", attrs["circuitbreaker.state"])
+	assert.Equal(t, "false", attrs["circuitbreaker.fallbacked"])
+	assert.Equal(t, "payments", attrs["circuitbreaker.name"])
+}
+
+func TestCallWithTracerTellsAboutTrips(t *testing.T) {
+	exporter, provider := createTracer()
+	cb, _ := createCircuitBreaker(failingService, fallback)
+	tracer := provider.Tracer("test")
+
+	for i := 0; i < cb.Settings.FailureThreshold+1; i++ {
+		cb.CallWithTracer(context.Background(), tracer)
+	}
+
+	spans := exporter.GetSpans()
+	assert.Equal(t, cb.Settings.FailureThreshold+1, len(spans))
+	assert.Equal(t, "circuitbreaker", spans[0].Name)
+	assert.Equal(t, "true", attributesOf(spans[0])["circuitbreaker.fallbacked"])
+	assert.Equal(t, "Error", spans[0].Status.Code.String())
+	assert.NotContains(t, eventsOf(spans[0]), "circuitbreaker.trip")
+	assert.Contains(t, eventsOf(spans[cb.Settings.FailureThreshold-1]), "circuitbreaker.trip")
+	assert.Equal(t, "open", attributesOf(spans[cb.Settings.FailureThreshold])["circuitbreaker.state"])
+}
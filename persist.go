@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// StateSnapshot is whatever a circuit breaker should not forget across restarts
+type StateSnapshot struct {
+	State           CircuitState `json:"state"`
+	ForcedOpen      bool         `json:"forcedOpen"`
+	FailureCount    int          `json:"failureCount"`
+	LastFailureTime time.Time    `json:"lastFailureTime"`
+}
+
+// StateStore keeps the state of a circuit breaker somewhere else, e.g. Redis,
+// so that it can be shared among replicas
+type StateStore interface {
+	// Load gives the state saved last
+	Load() (StateSnapshot, error)
+	// Save keeps the state for later on
+	Save(StateSnapshot) error
+}
+
+// MarshalJSON gives the state of the circuit breaker, so that it can be
+// restored later on by RestoreCircuitBreaker
+func (cb *CircuitBreaker) MarshalJSON() ([]byte, error) {
+	cb.mutex.RLock()
+	defer cb.mutex.RUnlock()
+
+	return json.Marshal(cb.snapshot())
+}
+
+// RestoreCircuitBreaker creates a circuit breaker which picks up where the one
+// marshalled into data left off. Functions and callbacks come from settings.
+func RestoreCircuitBreaker(settings CircuitSettings, data []byte) (*CircuitBreaker, error) {
+	var snapshot StateSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("Circuit breaker state could not be restored: %w", err)
+	}
+
+	cb, err := NewCircuitBreaker(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	cb.restore(snapshot)
+	return cb, nil
+}
+
+// snapshot must be called with the lock held
+func (cb *CircuitBreaker) snapshot() StateSnapshot {
+	return StateSnapshot{
+		State:           cb.state(),
+		ForcedOpen:      cb.forcedOpen,
+		FailureCount:    cb.FailureCount,
+		LastFailureTime: cb.LastFailureTime,
+	}
+}
+
+// restore must be called with the lock held
+func (cb *CircuitBreaker) restore(snapshot StateSnapshot) {
+	cb.FailureCount = snapshot.FailureCount
+	// There are no times to tell how old these are, so they are kept as they are
+	cb.trimmedFailures = snapshot.FailureCount
+	tripped := snapshot.State == IsOpen || snapshot.State == IsHalfOpen
+	if tripped && !snapshot.ForcedOpen {
+		// It was not closed on its own, so it has to look as bad as it did back then
+		cb.padFailures()
+	}
+	cb.forcedOpen = snapshot.ForcedOpen
+	cb.LastFailureTime = snapshot.LastFailureTime
+	cb.shuffleRetryJitter()
+	// Nothing has changed as far as anybody listening is concerned
+	cb.lastState = cb.state()
+}
+
+func (cb *CircuitBreaker) loadState() {
+	snapshot, err := cb.Settings.StateStore.Load()
+	if err != nil {
+		// Better start afresh than not start at all
+		return
+	}
+
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	cb.restore(snapshot)
+}
+
+func (cb *CircuitBreaker) saveState() {
+	cb.mutex.RLock()
+	snapshot := cb.snapshot()
+	cb.mutex.RUnlock()
+
+	// The store being out doesn't make the service any less healthy, so the
+	// circuit goes on as it is
+	cb.Settings.StateStore.Save(snapshot)
+}
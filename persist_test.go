@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func restoreWithClock(t *testing.T, cb *CircuitBreaker, clock Clock) *CircuitBreaker {
+	data, err := json.Marshal(cb)
+	assert.Nil(t, err)
+
+	settings := cb.Settings
+	settings.Clock = clock
+	restored, err := RestoreCircuitBreaker(settings, data)
+	assert.Nil(t, err)
+	return restored
+}
+
+func TestRestoreClosedCircuitBreaker(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := createCircuitBreakerWithClock(failingService, fallback, clock)
+	cb.Call()
+
+	restored := restoreWithClock(t, cb, clock)
+	assert.Equal(t, IsClosed, restored.State())
+	assert.Equal(t, 1, restored.CurrentFailureCount())
+	assert.Equal(t, cb.LastFailureTime, restored.LastFailureTime)
+
+	// one more is enough to trip it
+	restored.Call()
+	assert.Equal(t, IsOpen, restored.State())
+}
+
+func TestRestoreOpenCircuitBreaker(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := createCircuitBreakerWithClock(failingService, fallback, clock)
+	for i := 0; i < cb.Settings.FailureThreshold; i++ {
+		cb.Call()
+	}
+	assert.Equal(t, IsOpen, cb.State())
+
+	tripCount := 0
+	cb.Settings.OnTrip = func() {
+		tripCount++
+	}
+	restored := restoreWithClock(t, cb, clock)
+	assert.Equal(t, IsOpen, restored.State())
+	assert.Equal(t, cb.CurrentFailureCount(), restored.CurrentFailureCount())
+	assert.True(t, cb.LastFailureTime.Equal(restored.LastFailureTime))
+
+	// it was already open, so there is no news about it
+	_, _, err := restored.Call()
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, 0, tripCount)
+
+	clock.Advance(restored.Settings.RetryTimePeriod + time.Millisecond)
+	assert.Equal(t, IsHalfOpen, restored.State())
+}
+
+func TestRestoreOpenCircuitBreakerInPercentageMode(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := createCircuitBreakerWithClock(failingService, fallback, clock)
+	cb.Settings.ErrorPercentThreshold = 50
+	cb.Settings.RollingWindowSize = 4
+	for i := 0; i < cb.Settings.RollingWindowSize; i++ {
+		cb.Call()
+	}
+	assert.Equal(t, IsOpen, cb.State())
+
+	restored := restoreWithClock(t, cb, clock)
+	assert.Equal(t, IsOpen, restored.State())
+}
+
+func TestRestoreForcedOpenCircuitBreaker(t *testing.T) {
+	clock := newFakeClock()
+	cb, _ := createCircuitBreakerWithClock(healthService, fallback, clock)
+	cb.ForceOpen()
+
+	restored := restoreWithClock(t, cb, clock)
+	clock.Advance(restored.Settings.RetryTimePeriod + time.Millisecond)
+	assert.Equal(t, IsOpen, restored.State())
+
+	restored.ClearForced()
+	assert.Equal(t, IsClosed, restored.State())
+}
+
+func TestRestoreCircuitBreakerWithBadData(t *testing.T) {
+	cb, err := RestoreCircuitBreaker(CircuitSettings{Service: healthService}, []byte("not json"))
+	assert.Nil(t, cb)
+	assert.NotNil(t, err)
+}
+
+func TestRestoreCircuitBreakerWithNoService(t *testing.T) {
+	cb, err := RestoreCircuitBreaker(CircuitSettings{}, []byte("{}"))
+	assert.Nil(t, cb)
+	assert.NotNil(t, err)
+}
+
+func TestStateStoreIsSavedOnTripAndReset(t *testing.T) {
+	clock := newFakeClock()
+	store := &fakeStore{}
+	cb, _ := NewCircuitBreaker(CircuitSettings{
+		Service:    failingService,
+		Fallback:   fallback,
+		Clock:      clock,
+		StateStore: store,
+	})
+	assert.Equal(t, 1, store.loads)
+
+	for i := 0; i < cb.Settings.FailureThreshold; i++ {
+		cb.Call()
+	}
+	assert.Equal(t, 1, len(store.snapshots))
+	assert.Equal(t, IsOpen, store.snapshots[0].State)
+	assert.Equal(t, cb.CurrentFailureCount(), store.snapshots[0].FailureCount)
+	assert.Equal(t, cb.LastFailureTime, store.snapshots[0].LastFailureTime)
+
+	cb.Reset()
+	assert.Equal(t, 2, len(store.snapshots))
+	assert.Equal(t, IsClosed, store.snapshots[1].State)
+	assert.Equal(t, 0, store.snapshots[1].FailureCount)
+}
+
+func TestStateStoreIsLoadedOnCreation(t *testing.T) {
+	clock := newFakeClock()
+	store := &fakeStore{snapshots: []StateSnapshot{{
+		State:           IsOpen,
+		FailureCount:    5,
+		LastFailureTime: clock.Now(),
+	}}}
+	cb, _ := NewCircuitBreaker(CircuitSettings{
+		Service:    healthService,
+		Fallback:   fallback,
+		Clock:      clock,
+		StateStore: store,
+	})
+	assert.Equal(t, IsOpen, cb.State())
+	assert.Equal(t, 5, cb.CurrentFailureCount())
+
+	// another replica got it open already, so nothing is saved
+	cb.Call()
+	assert.Equal(t, 1, len(store.snapshots))
+
+	clock.Advance(cb.Settings.RetryTimePeriod + time.Millisecond)
+	cb.Call()
+	assert.Equal(t, IsClosed, cb.State())
+	assert.Equal(t, IsClosed, store.snapshots[len(store.snapshots)-1].State)
+}
+
+func TestStateStoreWithNothingSavedYet(t *testing.T) {
+	cb, _ := NewCircuitBreaker(CircuitSettings{
+		Service:    healthService,
+		StateStore: &fakeStore{},
+	})
+	assert.Equal(t, IsClosed, cb.State())
+	assert.Equal(t, 0, cb.CurrentFailureCount())
+}
+
+func TestStateStoreOutageLeavesCircuitAlone(t *testing.T) {
+	store := &fakeStore{err: errors.New("Store is down")}
+	cb, err := NewCircuitBreaker(CircuitSettings{
+		Service:    failingService,
+		Fallback:   fallback,
+		StateStore: store,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, IsClosed, cb.State())
+
+	for i := 0; i < cb.Settings.FailureThreshold; i++ {
+		cb.Call()
+	}
+	assert.Equal(t, IsOpen, cb.State())
+	assert.Equal(t, 0, len(store.snapshots))
+}
+
+func TestCloneLeavesStateStoreAlone(t *testing.T) {
+	store := &fakeStore{snapshots: []StateSnapshot{{State: IsOpen, FailureCount: 5}}}
+	cb, _ := NewCircuitBreaker(CircuitSettings{
+		Service:    failingService,
+		Fallback:   fallback,
+		StateStore: store,
+	})
+
+	clone := cb.Clone()
+	assert.Nil(t, clone.Settings.StateStore)
+	assert.Equal(t, 1, store.loads)
+	// it starts afresh rather than from the original's state
+	assert.Equal(t, IsClosed, clone.State())
+
+	for i := 0; i < clone.Settings.FailureThreshold; i++ {
+		clone.Call()
+	}
+	assert.Equal(t, IsOpen, clone.State())
+	assert.Equal(t, 1, len(store.snapshots))
+}
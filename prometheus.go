@@ -0,0 +1,56 @@
+//go:build prometheus
+
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Only built with the prometheus tag, so the circuit breaker itself doesn't
+// depend on it.
+
+// metricDescs tells the metrics of a circuit breaker apart from the ones of
+// any other by its name, so many of them can be registered at once
+func (cb *CircuitBreaker) metricDescs() (state, calls, failures, fallbacks *prometheus.Desc) {
+	labels := prometheus.Labels{"name": cb.CurrentSettings().Name}
+	state = prometheus.NewDesc(
+		"circuitbreaker_state",
+		"State of the circuit: 0 for closed, 1 for half-open, 2 for open.",
+		nil, labels)
+	calls = prometheus.NewDesc(
+		"circuitbreaker_calls_total",
+		"How many times the circuit breaker was called.",
+		nil, labels)
+	failures = prometheus.NewDesc(
+		"circuitbreaker_failures_total",
+		"How many calls ended up as a fail.",
+		nil, labels)
+	fallbacks = prometheus.NewDesc(
+		"circuitbreaker_fallbacks_total",
+		"How many times the fallback was called.",
+		nil, labels)
+	return
+}
+
+// Describe is part of the prometheus.Collector interface
+func (cb *CircuitBreaker) Describe(ch chan<- *prometheus.Desc) {
+	stateDesc, callsDesc, failuresDesc, fallbacksDesc := cb.metricDescs()
+	ch <- stateDesc
+	ch <- callsDesc
+	ch <- failuresDesc
+	ch <- fallbacksDesc
+}
+
+// Collect is part of the prometheus.Collector interface
+func (cb *CircuitBreaker) Collect(ch chan<- prometheus.Metric) {
+	stateDesc, callsDesc, failuresDesc, fallbacksDesc := cb.metricDescs()
+	metrics := cb.Metrics()
+
+	// Closed is the first valid state, so it counts as zero
+	state := float64(metrics.State - IsClosed)
+
+	ch <- prometheus.MustNewConstMetric(stateDesc, prometheus.GaugeValue, state)
+	ch <- prometheus.MustNewConstMetric(callsDesc, prometheus.CounterValue, float64(metrics.TotalCalls))
+	ch <- prometheus.MustNewConstMetric(failuresDesc, prometheus.CounterValue, float64(metrics.TotalFailures))
+	ch <- prometheus.MustNewConstMetric(fallbacksDesc, prometheus.CounterValue, float64(metrics.TotalFallbacks))
+}
@@ -0,0 +1,62 @@
+//go:build prometheus
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollectorIsRegisterable(t *testing.T) {
+	cb, _ := createCircuitBreaker(healthService, fallback)
+
+	registry := prometheus.NewRegistry()
+	assert.Nil(t, registry.Register(cb))
+}
+
+func TestCollectorsOfManyAreRegisterable(t *testing.T) {
+	payments, _ := NewCircuitBreaker(CircuitSettings{Name: "payments", Service: healthService})
+	orders, _ := NewCircuitBreaker(CircuitSettings{Name: "orders", Service: failingService, Fallback: fallback})
+	orders.Call()
+
+	registry := prometheus.NewRegistry()
+	assert.Nil(t, registry.Register(payments))
+	assert.Nil(t, registry.Register(orders))
+
+	expected := `
+# HELP circuitbreaker_failures_total How many calls ended up as a fail.
+# TYPE circuitbreaker_failures_total counter
+circuitbreaker_failures_total{name="orders"} 1
+circuitbreaker_failures_total{name="payments"} 0
+`
+	err := testutil.GatherAndCompare(registry, strings.NewReader(expected), "circuitbreaker_failures_total")
+	assert.Nil(t, err)
+}
+
+func TestCollectorExportsMetrics(t *testing.T) {
+	cb, _ := createCircuitBreaker(failingService, fallback)
+	for i := 0; i < cb.Settings.FailureThreshold+1; i++ {
+		cb.Call()
+	}
+
+	expected := `
+# HELP circuitbreaker_calls_total How many times the circuit breaker was called.
+# TYPE circuitbreaker_calls_total counter
+circuitbreaker_calls_total{name=""} 3
+# HELP circuitbreaker_failures_total How many calls ended up as a fail.
+# TYPE circuitbreaker_failures_total counter
+circuitbreaker_failures_total{name=""} 2
+# HELP circuitbreaker_fallbacks_total How many times the fallback was called.
+# TYPE circuitbreaker_fallbacks_total counter
+circuitbreaker_fallbacks_total{name=""} 3
+# HELP circuitbreaker_state State of the circuit: 0 for closed, 1 for half-open, 2 for open.
+# TYPE circuitbreaker_state gauge
+circuitbreaker_state{name=""} 2
+`
+	err := testutil.CollectAndCompare(cb, strings.NewReader(expected))
+	assert.Nil(t, err)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// FallbackReason tells why a call was fallbacked
+type FallbackReason int
+
+const (
+	// ReasonNone is for calls that were not fallbacked at all
+	ReasonNone FallbackReason = iota
+	// ReasonTimeout is when the service took too long to respond
+	ReasonTimeout
+	// ReasonServiceError is when the service responded with an error
+	ReasonServiceError
+	// ReasonCircuitOpen is when the service was not called because the
+	// circuit is open
+	ReasonCircuitOpen
+	// ReasonBulkheadFull is when the service was not called because there
+	// were too many calls at once
+	ReasonBulkheadFull
+	// ReasonCallTooSoon is when the service was not called because it was
+	// called too recently, as far as MinCallInterval is concerned
+	ReasonCallTooSoon
+)
+
+// ToString of FallbackReason type
+func (r FallbackReason) ToString() string {
+	switch r {
+	case ReasonNone:
+		return "none"
+	case ReasonTimeout:
+		return "timeout"
+	case ReasonServiceError:
+		return "service-error"
+	case ReasonCircuitOpen:
+		return "circuit-open"
+	case ReasonBulkheadFull:
+		return "bulkhead-full"
+	case ReasonCallTooSoon:
+		return "call-too-soon"
+	default:
+		return "invalid"
+	}
+}
+
+// CallWithReason is the same as Call but it also tells why it was
+// fallbacked, if it was
+func (cb *CircuitBreaker) CallWithReason() (interface{}, bool, FallbackReason, error) {
+	return cb.callWithReason(context.Background(), nil, 0)
+}
+
+// fallbackReason looks into the error before it is dressed up for the caller
+func fallbackReason(fallbacked bool, err error) FallbackReason {
+	switch {
+	case !fallbacked:
+		return ReasonNone
+	case errors.Is(err, ErrCircuitOpen):
+		return ReasonCircuitOpen
+	case errors.Is(err, ErrBulkheadFull):
+		return ReasonBulkheadFull
+	case errors.Is(err, ErrCallTooSoon):
+		return ReasonCallTooSoon
+	case errors.Is(err, ErrServiceTimeout):
+		return ReasonTimeout
+	default:
+		return ReasonServiceError
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReasonNoneWhenHealthy(t *testing.T) {
+	cb, _ := createCircuitBreaker(healthService, fallback)
+
+	res, fallbacked, reason, err := cb.CallWithReason()
+	assert.Equal(t, healthServiceContent, res)
+	assert.False(t, fallbacked)
+	assert.Equal(t, ReasonNone, reason)
+	assert.Nil(t, err)
+}
+
+func TestReasonServiceError(t *testing.T) {
+	cb, _ := createCircuitBreaker(failingService, fallback)
+
+	_, fallbacked, reason, _ := cb.CallWithReason()
+	assert.True(t, fallbacked)
+	assert.Equal(t, ReasonServiceError, reason)
+}
+
+func TestReasonTimeout(t *testing.T) {
+	cb, _ := createCircuitBreaker(slowService, fallback)
+	cb.Settings.Timeout = 10 * time.Millisecond
+
+	_, fallbacked, reason, _ := cb.CallWithReason()
+	assert.True(t, fallbacked)
+	assert.Equal(t, ReasonTimeout, reason)
+}
+
+func TestReasonCircuitOpen(t *testing.T) {
+	cb, _ := createCircuitBreaker(healthService, fallback)
+	cb.Trip()
+
+	_, fallbacked, reason, _ := cb.CallWithReason()
+	assert.True(t, fallbacked)
+	assert.Equal(t, ReasonCircuitOpen, reason)
+}
+
+func TestReasonBulkheadFull(t *testing.T) {
+	var hits int32
+	release := make(chan struct{})
+	defer close(release)
+	cb, _ := NewCircuitBreaker(CircuitSettings{
+		Service:            createBlockedService(&hits, release),
+		Fallback:           fallback,
+		MaxConcurrentCalls: 1,
+	})
+	go cb.Call()
+	for atomic.LoadInt32(&hits) < 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	_, fallbacked, reason, _ := cb.CallWithReason()
+	assert.True(t, fallbacked)
+	assert.Equal(t, ReasonBulkheadFull, reason)
+}
+
+func TestReasonSurvivesTreatFallbackAsSuccess(t *testing.T) {
+	cb, _ := createCircuitBreaker(failingService, fallback)
+	cb.Settings.TreatFallbackAsSuccess = true
+
+	_, fallbacked, reason, err := cb.CallWithReason()
+	assert.Nil(t, err)
+	assert.True(t, fallbacked)
+	assert.Equal(t, ReasonServiceError, reason)
+}
+
+func TestReasonNoneWithNoFallback(t *testing.T) {
+	cb, _ := createCircuitBreakerWithNoFallback(failingService)
+
+	_, fallbacked, reason, err := cb.CallWithReason()
+	assert.NotNil(t, err)
+	assert.False(t, fallbacked)
+	assert.Equal(t, ReasonNone, reason)
+}
+
+func TestReasonToString(t *testing.T) {
+	assert.Equal(t, "timeout", ReasonTimeout.ToString())
+	assert.Equal(t, "circuit-open", ReasonCircuitOpen.ToString())
+	assert.Equal(t, "invalid", FallbackReason(42).ToString())
+}
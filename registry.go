@@ -0,0 +1,62 @@
+package main
+
+import (
+	"sync"
+)
+
+// Registry keeps track of circuit breakers by name, so that a service with
+// many dependencies has a single place to look them up
+type Registry struct {
+	mutex    sync.RWMutex
+	breakers map[string]*CircuitBreaker
+}
+
+// NewRegistry builds an empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		breakers: map[string]*CircuitBreaker{},
+	}
+}
+
+// GetOrCreate gives the circuit breaker registered under that name or, in
+// absense of that, builds one from the settings spec and registers it
+func (r *Registry) GetOrCreate(name string, settings CircuitSettings) (*CircuitBreaker, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if cb, ok := r.breakers[name]; ok {
+		return cb, nil
+	}
+
+	cb, err := NewCircuitBreaker(settings)
+	if err != nil {
+		return nil, err
+	}
+	if r.breakers == nil {
+		r.breakers = map[string]*CircuitBreaker{}
+	}
+	r.breakers[name] = cb
+	return cb, nil
+}
+
+// Get gives the circuit breaker registered under that name, if any
+func (r *Registry) Get(name string) (*CircuitBreaker, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	cb, ok := r.breakers[name]
+	return cb, ok
+}
+
+// All gives every registered circuit breaker by name
+func (r *Registry) All() map[string]*CircuitBreaker {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	// A copy, so callers can't mess with the registry
+	all := make(map[string]*CircuitBreaker, len(r.breakers))
+	for name, cb := range r.breakers {
+		all[name] = cb
+	}
+	return all
+}
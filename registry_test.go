@@ -0,0 +1,79 @@
+package main
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistryCreatesOnlyOnce(t *testing.T) {
+	r := NewRegistry()
+
+	cb1, err := r.GetOrCreate("health", CircuitSettings{Service: healthService})
+	assert.Nil(t, err)
+	assert.NotNil(t, cb1)
+
+	cb2, err := r.GetOrCreate("health", CircuitSettings{Service: slowService})
+	assert.Nil(t, err)
+	assert.Same(t, cb1, cb2)
+}
+
+func TestRegistryDoesNotKeepFailedCreation(t *testing.T) {
+	r := NewRegistry()
+
+	cb, err := r.GetOrCreate("none", CircuitSettings{})
+	assert.NotNil(t, err)
+	assert.Nil(t, cb)
+
+	_, ok := r.Get("none")
+	assert.False(t, ok)
+}
+
+func TestRegistryGet(t *testing.T) {
+	r := NewRegistry()
+
+	_, ok := r.Get("health")
+	assert.False(t, ok)
+
+	created, _ := r.GetOrCreate("health", CircuitSettings{Service: healthService})
+	cb, ok := r.Get("health")
+	assert.True(t, ok)
+	assert.Same(t, created, cb)
+}
+
+func TestRegistryAll(t *testing.T) {
+	r := NewRegistry()
+	health, _ := r.GetOrCreate("health", CircuitSettings{Service: healthService})
+	slow, _ := r.GetOrCreate("slow", CircuitSettings{Service: slowService})
+
+	all := r.All()
+	assert.Equal(t, 2, len(all))
+	assert.Same(t, health, all["health"])
+	assert.Same(t, slow, all["slow"])
+
+	// messing with it doesn't mess with the registry
+	delete(all, "health")
+	_, ok := r.Get("health")
+	assert.True(t, ok)
+}
+
+func TestRegistryConcurrentGetOrCreate(t *testing.T) {
+	var registry Registry
+	breakers := make([]*CircuitBreaker, 100)
+
+	var wg sync.WaitGroup
+	for i := range breakers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			breakers[i], _ = registry.GetOrCreate("health", CircuitSettings{Service: healthService})
+		}(i)
+	}
+	wg.Wait()
+
+	for _, cb := range breakers {
+		assert.Same(t, breakers[0], cb)
+	}
+	assert.Equal(t, 1, len(registry.All()))
+}
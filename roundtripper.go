@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// HTTPStatusError is what a circuit breaker round tripper gets when the
+// server responds with a 5xx status
+type HTTPStatusError struct {
+	Response *http.Response
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("Service responded with status %s", e.Response.Status)
+}
+
+type roundTripper struct {
+	next http.RoundTripper
+	cb   *CircuitBreaker
+}
+
+// NewRoundTripper wraps an http.RoundTripper into a circuit breaker. Transport
+// errors and 5xx responses are taken as fails, unless settings tell otherwise
+// through IsFailure. There is no need for a service in the settings spec,
+// because every request is a service call of its own. A fallback, if any, must
+// respond an *http.Response, and in absense of one an open circuit responds
+// a 503.
+func NewRoundTripper(next http.RoundTripper, settings CircuitSettings) (http.RoundTripper, error) {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	cb, err := NewManualCircuitBreaker(settings)
+	if err != nil {
+		return nil, err
+	}
+	return &roundTripper{
+		next: next,
+		cb:   cb,
+	}, nil
+}
+
+// RoundTrip is part of the http.RoundTripper interface
+func (rt *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Once the circuit breaker gives up on the request, e.g. it timed out, the
+	// request is cancelled rather than left running on its own
+	ctx, cancel := context.WithCancel(req.Context())
+	late := &lateResponse{}
+	res, fallbacked, err := rt.cb.call(ctx, func() (interface{}, error) {
+		res, err := roundTrip(rt.next, req.WithContext(ctx))
+		if resp, ok := res.(*http.Response); ok {
+			late.keep(resp)
+		}
+		return res, err
+	}, 0)
+
+	resp, _ := res.(*http.Response)
+	served := late.giveUp()
+	if served != nil && served != resp {
+		// It responded after all, but too late for anyone to read it
+		served.Body.Close()
+	}
+	if resp != nil && resp == served {
+		// Its body is yet to be read, so the request is done only once it is
+		// closed
+		resp.Body = &cancelOnClose{resp.Body, cancel}
+		return resp, nil
+	}
+	cancel()
+
+	if resp != nil {
+		if resp.Request == nil {
+			// It came from a fallback
+			resp.Request = req
+		}
+		return resp, nil
+	}
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) {
+		// Not taken as a fail, so it is just a regular response
+		return statusErr.Response, nil
+	}
+	if !fallbacked && errors.Is(err, ErrCircuitOpen) {
+		return serviceUnavailable(req), nil
+	}
+	return nil, err
+}
+
+// lateResponse keeps the response the service came up with, so that it can
+// be closed when the circuit breaker gave up on it already
+type lateResponse struct {
+	mutex  sync.Mutex
+	resp   *http.Response
+	gaveUp bool
+}
+
+func (l *lateResponse) keep(resp *http.Response) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.gaveUp {
+		// Nobody is waiting for it anymore
+		resp.Body.Close()
+		return
+	}
+	l.resp = resp
+}
+
+func (l *lateResponse) giveUp() *http.Response {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.gaveUp = true
+	return l.resp
+}
+
+// cancelOnClose cancels the request once its body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+func roundTrip(next http.RoundTripper, req *http.Request) (interface{}, error) {
+	resp, err := next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		// The response may end up dropped in favor of a fallback, so we read it
+		// right away and don't leave the connection hanging
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		return nil, &HTTPStatusError{resp}
+	}
+	return resp, nil
+}
+
+// serviceUnavailable is what an open circuit responds in absense of a fallback
+func serviceUnavailable(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", http.StatusServiceUnavailable, http.StatusText(http.StatusServiceUnavailable)),
+		StatusCode: http.StatusServiceUnavailable,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{},
+		Body:       io.NopCloser(bytes.NewReader(nil)),
+		Request:    req,
+	}
+}
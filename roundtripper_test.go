@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func createServer(status int, hits *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(hits, 1)
+		w.WriteHeader(status)
+		w.Write([]byte(http.StatusText(status)))
+	}))
+}
+
+func createClient(settings CircuitSettings) *http.Client {
+	rt, _ := NewRoundTripper(nil, settings)
+	return &http.Client{Transport: rt}
+}
+
+func TestRoundTripperTripsOnServerErrors(t *testing.T) {
+	var hits int32
+	server := createServer(http.StatusInternalServerError, &hits)
+	defer server.Close()
+
+	client := createClient(CircuitSettings{})
+
+	for i := 0; i < DefautlFailureThreshold; i++ {
+		// the server responds while the circuit is closed
+		resp, err := client.Get(server.URL)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusText(http.StatusInternalServerError), string(body))
+	}
+
+	for i := 0; i < 3; i++ {
+		// but now it is open and the server is left alone
+		resp, err := client.Get(server.URL)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+		resp.Body.Close()
+	}
+	assert.Equal(t, int32(DefautlFailureThreshold), atomic.LoadInt32(&hits))
+}
+
+func TestRoundTripperDoesNotTripOnClientErrors(t *testing.T) {
+	var hits int32
+	server := createServer(http.StatusNotFound, &hits)
+	defer server.Close()
+
+	client := createClient(CircuitSettings{})
+
+	for i := 0; i < 2*DefautlFailureThreshold; i++ {
+		resp, err := client.Get(server.URL)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		resp.Body.Close()
+	}
+	assert.Equal(t, int32(2*DefautlFailureThreshold), atomic.LoadInt32(&hits))
+}
+
+func TestRoundTripperRelyOnFallback(t *testing.T) {
+	var hits int32
+	server := createServer(http.StatusBadGateway, &hits)
+	defer server.Close()
+
+	client := createClient(CircuitSettings{
+		Fallback: func() (interface{}, error) {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{},
+				Body:       io.NopCloser(bytes.NewBufferString(fallbackContent)),
+			}, nil
+		},
+	})
+
+	for i := 0; i < DefautlFailureThreshold+2; i++ {
+		resp, err := client.Get(server.URL)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, fallbackContent, string(body))
+	}
+	assert.Equal(t, int32(DefautlFailureThreshold), atomic.LoadInt32(&hits))
+}
+
+func TestRoundTripperWithClassifier(t *testing.T) {
+	var hits int32
+	server := createServer(http.StatusNotImplemented, &hits)
+	defer server.Close()
+
+	client := createClient(CircuitSettings{
+		IsFailure: func(err error) bool {
+			statusErr, ok := err.(*HTTPStatusError)
+			return !ok || statusErr.Response.StatusCode != http.StatusNotImplemented
+		},
+	})
+
+	for i := 0; i < 2*DefautlFailureThreshold; i++ {
+		resp, err := client.Get(server.URL)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+		resp.Body.Close()
+	}
+	assert.Equal(t, int32(2*DefautlFailureThreshold), atomic.LoadInt32(&hits))
+}
+
+func TestRoundTripperWithClassifierWhenNamed(t *testing.T) {
+	var hits int32
+	server := createServer(http.StatusServiceUnavailable, &hits)
+	defer server.Close()
+
+	client := createClient(CircuitSettings{
+		Name: "payments",
+		IsFailure: func(err error) bool {
+			var statusErr *HTTPStatusError
+			return !errors.As(err, &statusErr) || statusErr.Response.StatusCode != http.StatusServiceUnavailable
+		},
+	})
+
+	// the name in the error must not hide the response
+	resp, err := client.Get(server.URL)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusText(http.StatusServiceUnavailable), string(body))
+}
+
+func TestRoundTripperTripsOnServerErrorsWhenNamed(t *testing.T) {
+	var hits int32
+	server := createServer(http.StatusInternalServerError, &hits)
+	defer server.Close()
+
+	client := createClient(CircuitSettings{Name: "payments"})
+
+	for i := 0; i < DefautlFailureThreshold+2; i++ {
+		resp, err := client.Get(server.URL)
+		assert.Nil(t, err)
+		assert.GreaterOrEqual(t, resp.StatusCode, http.StatusInternalServerError)
+		resp.Body.Close()
+	}
+	assert.Equal(t, int32(DefautlFailureThreshold), atomic.LoadInt32(&hits))
+}
+
+func TestRoundTripperTransportError(t *testing.T) {
+	var hits int32
+	server := createServer(http.StatusOK, &hits)
+	server.Close()
+
+	client := createClient(CircuitSettings{})
+
+	resp, err := client.Get(server.URL)
+	assert.NotNil(t, err)
+	assert.Nil(t, resp)
+	// the cause is told only once
+	assert.Equal(t, 1, strings.Count(err.Error(), "connection refused"))
+}
+
+func TestRoundTripperWithInvalidSettings(t *testing.T) {
+	rt, err := NewRoundTripper(nil, CircuitSettings{Timeout: -time.Second})
+	assert.NotNil(t, err)
+	assert.Nil(t, rt)
+}
+
+func TestRoundTripperKeepsBodyReadable(t *testing.T) {
+	var hits int32
+	server := createServer(http.StatusOK, &hits)
+	defer server.Close()
+
+	client := createClient(CircuitSettings{})
+
+	resp, err := client.Get(server.URL)
+	assert.Nil(t, err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusText(http.StatusOK), string(body))
+}
+
+func TestRoundTripperCancelsTimedOutRequests(t *testing.T) {
+	var cancelled int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			atomic.StoreInt32(&cancelled, 1)
+		case <-time.After(time.Second):
+		}
+	}))
+	defer server.Close()
+
+	client := createClient(CircuitSettings{Timeout: 10 * time.Millisecond})
+
+	_, err := client.Get(server.URL)
+	assert.ErrorIs(t, err, ErrServiceTimeout)
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&cancelled) == 1
+	}, time.Second, time.Millisecond)
+}
+
+type closeRecorder struct {
+	io.Reader
+	closed *int32
+}
+
+func (c closeRecorder) Close() error {
+	atomic.StoreInt32(c.closed, 1)
+	return nil
+}
+
+type sleepyTransport struct {
+	sleep  time.Duration
+	closed *int32
+}
+
+func (s sleepyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// it pays no attention to the request being cancelled
+	time.Sleep(s.sleep)
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+		Body:       closeRecorder{strings.NewReader(""), s.closed},
+		Request:    req,
+	}, nil
+}
+
+func TestRoundTripperClosesLateResponses(t *testing.T) {
+	var closed int32
+	rt, _ := NewRoundTripper(sleepyTransport{50 * time.Millisecond, &closed}, CircuitSettings{Timeout: 10 * time.Millisecond})
+	client := &http.Client{Transport: rt}
+
+	_, err := client.Get("http://example.com")
+	assert.ErrorIs(t, err, ErrServiceTimeout)
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&closed) == 1
+	}, time.Second, time.Millisecond)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+)
+
+// TypedCircuitSettings is the spec to build a TypedCircuitBreaker instance.
+// Everything but the service and its fallback works as in CircuitSettings.
+type TypedCircuitSettings[T any] struct {
+	CircuitSettings
+	// Target service
+	Service func() (T, error)
+	// Fallback when service is unhealth
+	Fallback func() (T, error)
+}
+
+// TypedCircuitBreaker is a circuit breaker that spares its callers from
+// type asserting every response
+type TypedCircuitBreaker[T any] struct {
+	*CircuitBreaker
+}
+
+// NewTypedCircuitBreaker builds a typed circuit breaker from a settings spec
+func NewTypedCircuitBreaker[T any](settings TypedCircuitSettings[T]) (*TypedCircuitBreaker[T], error) {
+	// Underneath it is the very same circuit breaker
+	untyped := settings.CircuitSettings
+	if settings.Service != nil {
+		untyped.Service = func() (interface{}, error) {
+			return settings.Service()
+		}
+	}
+	if settings.Fallback != nil {
+		untyped.Fallback = func() (interface{}, error) {
+			return settings.Fallback()
+		}
+	}
+
+	cb, err := NewCircuitBreaker(untyped)
+	if err != nil {
+		return nil, err
+	}
+	return &TypedCircuitBreaker[T]{cb}, nil
+}
+
+// Call is the circuit break safe call to a service.
+// Returns:
+// - Service actual response content or the zero value of T;
+// - True if relying on fallback, False otherwise;
+// - An error or nil otherwise.
+func (tcb *TypedCircuitBreaker[T]) Call() (T, bool, error) {
+	return tcb.CallContext(context.Background())
+}
+
+// CallContext is the same as Call but the service call is abandoned as soon
+// as the given context is done.
+func (tcb *TypedCircuitBreaker[T]) CallContext(ctx context.Context) (T, bool, error) {
+	res, fallbacked, err := tcb.CircuitBreaker.CallContext(ctx)
+	// No content at all means the zero value
+	content, _ := res.(T)
+	return content, fallbacked, err
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type typedContent struct {
+	Message string
+	Count   int
+}
+
+func TestTypedErrorOnCreationWithoutProvideAService(t *testing.T) {
+	tcb, err := NewTypedCircuitBreaker(TypedCircuitSettings[string]{})
+	assert.NotNil(t, err)
+	assert.Nil(t, tcb)
+}
+
+func TestTypedServiceIsHealth(t *testing.T) {
+	tcb, err := NewTypedCircuitBreaker(TypedCircuitSettings[string]{
+		Service: func() (string, error) {
+			return healthServiceContent, nil
+		},
+	})
+	assert.Nil(t, err)
+
+	res, fallbacked, err := tcb.Call()
+	assert.Nil(t, err)
+	assert.False(t, fallbacked)
+	assert.Equal(t, healthServiceContent, res)
+	assert.Equal(t, IsClosed, tcb.State())
+}
+
+func TestTypedServiceFailsWithoutFallback(t *testing.T) {
+	tcb, _ := NewTypedCircuitBreaker(TypedCircuitSettings[typedContent]{
+		Service: func() (typedContent, error) {
+			return typedContent{"failed", 1}, failingServiceError
+		},
+	})
+
+	res, fallbacked, err := tcb.Call()
+	assert.NotNil(t, err)
+	assert.False(t, fallbacked)
+	assert.Equal(t, typedContent{}, res)
+}
+
+func TestTypedServiceIsFallbacked(t *testing.T) {
+	tcb, _ := NewTypedCircuitBreaker(TypedCircuitSettings[typedContent]{
+		Service: func() (typedContent, error) {
+			return typedContent{}, failingServiceError
+		},
+		Fallback: func() (typedContent, error) {
+			return typedContent{fallbackContent, 42}, nil
+		},
+	})
+
+	for i := 0; i < tcb.Settings.FailureThreshold; i++ {
+		res, fallbacked, err := tcb.Call()
+		assert.Contains(t, err.Error(), fallbackDueToErrorMessage)
+		assert.True(t, fallbacked)
+		assert.Equal(t, typedContent{fallbackContent, 42}, res)
+	}
+	assert.Equal(t, IsOpen, tcb.State())
+
+	res, fallbacked, err := tcb.Call()
+	assert.Contains(t, err.Error(), fallbackDueToOpenStateMessage)
+	assert.True(t, fallbacked)
+	assert.Equal(t, typedContent{fallbackContent, 42}, res)
+}